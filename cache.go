@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nishidy/rsb/trace"
+)
+
+func getHashedDir(file_path, func_name string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(file_path+func_name)))
+}
+
+func dirExists(abs_path string) bool {
+	_, err := os.Stat(abs_path)
+	return err == nil
+}
+
+func getAbsHashedDir(file_path, func_name string) string {
+	home_path := trace.GetHomeEnv()
+	hashed_dir := getHashedDir(file_path, func_name)
+	abs_hashed_dir := filepath.Join(home_path, trace.BTHOME, hashed_dir)
+	return abs_hashed_dir
+}
+
+// getCachedResult returns "" without error when nothing is cached yet.
+func getCachedResult(file_path, func_name string) (string, error) {
+
+	abs_hashed_dir := getAbsHashedDir(file_path, func_name)
+
+	if !dirExists(abs_hashed_dir) {
+		return "", nil
+	}
+
+	result, err := ioutil.ReadFile(filepath.Join(abs_hashed_dir, "result"))
+	if err != nil {
+		return "", fmt.Errorf("Cannot read the cached result: %s", err.Error())
+	}
+	return string(result), nil
+}
+
+// printCachedResult only warns on errors because the search goes on anyway.
+func printCachedResult(path, func_name string) {
+
+	file_path, err := filepath.Abs(path)
+	if err != nil {
+		warn(err)
+		return
+	}
+
+	result, err := getCachedResult(file_path, func_name)
+	if err != nil {
+		warn(err)
+	} else if result != "" {
+		fmt.Println("# Show cached result.")
+		fmt.Println(result)
+		fmt.Println("# Go on search...")
+	}
+}
+
+func saveResult(tree *trace.Tree, shows *trace.ShowsInfo) error {
+	file_path, err := filepath.Abs(tree.Root.Nodes()[0].Site().File)
+	if err != nil {
+		return err
+	}
+	func_name := tree.Root.Nodes()[0].Callee().Fun
+	abs_hashed_dir := getAbsHashedDir(file_path, func_name)
+
+	if dirExists(abs_hashed_dir) {
+		os.RemoveAll(abs_hashed_dir)
+		fmt.Println("# Overwrite the cache.")
+	}
+
+	if err := os.MkdirAll(abs_hashed_dir, 0755); err != nil {
+		return fmt.Errorf("Cannot save the result: %s", err.Error())
+	}
+
+	show := shows.Join("")
+	if err := ioutil.WriteFile(filepath.Join(abs_hashed_dir, "result"), []byte(show), 0400); err != nil {
+		return fmt.Errorf("Cannot save the result: %s", err.Error())
+	}
+	return nil
+}
+
+// cachedResults returns the directories of the cached results, which are
+// every directory under BTHOME except the index.
+func cachedResults() ([]string, error) {
+	home := filepath.Join(trace.GetHomeEnv(), trace.BTHOME)
+
+	infos, err := ioutil.ReadDir(home)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{}
+	for _, info := range infos {
+		if info.IsDir() && info.Name() != trace.INDEXDIR {
+			dirs = append(dirs, filepath.Join(home, info.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+// firstLine is the line of the entry point in a cached result.
+func firstLine(path string) string {
+	fd, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer fd.Close()
+
+	sc := bufio.NewScanner(fd)
+	sc.Scan()
+	return removeAnsiCode(sc.Text())
+}
+
+const CACHEUSAGE = "rsb cache [flags] list|clear"
+
+// runCache lists the cached results or removes them, and the persisted
+// indexes too with --index.
+func runCache(args []string) error {
+
+	with_index := false
+
+	fs := newFlagSet("cache")
+	fs.BoolVar(&with_index, "index", false, "Remove the indexes too with clear")
+
+	positionals, err := parseFlags(fs, CACHEUSAGE, args)
+	if err != nil {
+		return err
+	}
+	if len(positionals) != 1 {
+		return &usageError{"Missing list or clear.", CACHEUSAGE}
+	}
+
+	dirs, err := cachedResults()
+	if err != nil {
+		return err
+	}
+
+	switch positionals[0] {
+	case "list":
+		for _, dir := range dirs {
+			info, err := os.Stat(filepath.Join(dir, "result"))
+			if err != nil {
+				continue
+			}
+			fmt.Printf("%s %s %s\n", filepath.Base(dir), info.ModTime().Format("2006-01-02 15:04:05"),
+				firstLine(filepath.Join(dir, "result")))
+		}
+
+	case "clear":
+		for _, dir := range dirs {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("# Removed %d cached results.\n", len(dirs))
+
+		if with_index {
+			index := filepath.Join(trace.GetHomeEnv(), trace.BTHOME, trace.INDEXDIR)
+			if err := os.RemoveAll(index); err != nil {
+				return err
+			}
+			fmt.Println("# Removed the indexes.")
+		}
+
+	default:
+		return &usageError{"Unknown cache command " + positionals[0] + ".", CACHEUSAGE}
+	}
+
+	return nil
+}
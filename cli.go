@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nishidy/rsb/trace"
+)
+
+// command is a subcommand of rsb.
+type command struct {
+	name  string
+	usage string
+	brief string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"backtrace", BACKTRACEUSAGE, "Show the callers of a function recursively",
+		func(args []string) error { return runBacktrace("backtrace", args, false) }},
+	{"forward", FORWARDUSAGE, "Show the callees of a function recursively",
+		func(args []string) error { return runBacktrace("forward", args, true) }},
+	{"path", PATHUSAGE, "Show every call chain between two functions", runPath},
+	{"index", INDEXUSAGE, "Build or check the index of a root directory", runIndex},
+	{"cache", CACHEUSAGE, "List or clear the cached results", runCache},
+}
+
+func printUsage() {
+	fmt.Println("usage: rsb COMMAND [flags] [args]")
+	fmt.Println("       " + LEGACYUSAGE)
+	fmt.Println()
+	fmt.Println("commands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-10s %s\n", cmd.name, cmd.brief)
+	}
+	fmt.Println()
+	fmt.Println("Run 'rsb COMMAND --help' for the flags of each command.")
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// newFlagSet does not print anything by itself, errors are returned to main
+// and --help is printed by parseFlags.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs
+}
+
+// listFlag is a flag which may be given several times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// defineFlags are -D and -U of cc, given either as "-D NAME" or "-DNAME".
+func defineFlags(fs *flag.FlagSet) {
+	fs.Var(&listFlag{}, "D", "Define `NAME[=VALUE]` for #if, which may be given several times")
+	fs.Var(&listFlag{}, "U", "Undefine `NAME` for #if, which may be given several times")
+}
+
+// parseFlags parses args where flags and positional arguments may be mixed,
+// e.g. "--raw FILE LINE", and returns the positional ones. flag.ErrHelp is
+// returned after the usage is printed for --help.
+func parseFlags(fs *flag.FlagSet, usage string, args []string) ([]string, error) {
+
+	if fs.Lookup("D") != nil {
+		split := []string{}
+		for _, arg := range args {
+			if len(arg) > 2 && (strings.HasPrefix(arg, "-D") || strings.HasPrefix(arg, "-U")) && arg[2] != '=' {
+				split = append(split, arg[:2], arg[2:])
+				continue
+			}
+			split = append(split, arg)
+		}
+		args = split
+	}
+
+	positionals := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				fmt.Println("usage: " + usage)
+				fmt.Println()
+				fmt.Println("flags:")
+				fs.SetOutput(os.Stdout)
+				fs.PrintDefaults()
+				return nil, err
+			}
+			return nil, &usageError{err.Error(), usage}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positionals, nil
+		}
+		positionals = append(positionals, args[0])
+		args = args[1:]
+	}
+}
+
+func givenFlags(fs *flag.FlagSet) map[string]bool {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return given
+}
+
+// fillPositionals sets the named flags which were not given on the command
+// line from positionals, in order, and returns the names still missing.
+func fillPositionals(fs *flag.FlagSet, usage string, positionals []string, names ...string) ([]string, error) {
+	given := givenFlags(fs)
+	missing := []string{}
+
+	for _, name := range names {
+		if given[name] {
+			continue
+		}
+		if len(positionals) == 0 {
+			missing = append(missing, name)
+			continue
+		}
+		if err := fs.Set(name, positionals[0]); err != nil {
+			return nil, &usageError{"Invalid " + strings.ToUpper(name) + " " + positionals[0] + ".", usage}
+		}
+		positionals = positionals[1:]
+	}
+
+	if len(positionals) > 0 {
+		return nil, &usageError{"Too many arguments " + strings.Join(positionals, " ") + ".", usage}
+	}
+	return missing, nil
+}
+
+// without returns the defines whose names are not in names.
+func without(defines, names []string) []string {
+	result := []string{}
+	for _, define := range defines {
+		found := false
+		for _, name := range names {
+			if strings.SplitN(define, "=", 2)[0] == strings.SplitN(name, "=", 2)[0] {
+				found = true
+			}
+		}
+		if !found {
+			result = append(result, define)
+		}
+	}
+	return result
+}
+
+func missingError(missing []string, usage string) error {
+	return &usageError{"Missing " + strings.ToUpper(strings.Join(missing, " ")) + ".", usage}
+}
+
+// vcsFlag is shared by the commands which search or index a root.
+func vcsFlag(fs *flag.FlagSet) {
+	fs.String("vcs", trace.VCSAUTO, "Which files of a git repository to search, one of auto, ignore, tracked and none")
+}
+
+// parserFlag chooses how the decls and the calls are found.
+func parserFlag(fs *flag.FlagSet) {
+	fs.String("parser", trace.PARSERRAW, "`PARSER` of the sources, raw or clang")
+	fs.String("compdb", "", "`PATH` of the "+trace.COMPDBFILE+" giving the flags of clang, ROOT/"+trace.COMPDBFILE+" by default")
+}
+
+// loadConfig finds the config of the root given by the flag "root" and sets
+// the flags which were not given on the command line from it. missing are
+// the mandatory flags not given at all, of which only "depth" can be taken
+// from the config.
+func loadConfig(fs *flag.FlagSet, usage string, missing []string, raw *bool) (*trace.Config, error) {
+
+	for _, name := range missing {
+		if name != "depth" {
+			return nil, missingError(missing, usage)
+		}
+	}
+
+	cfg, err := trace.FindConfig(fs.Lookup("root").Value.String())
+	if err != nil {
+		return nil, err
+	}
+
+	given := givenFlags(fs)
+
+	if given["vcs"] {
+		cfg.VCS = fs.Lookup("vcs").Value.String()
+	}
+	if given["parser"] {
+		cfg.Parser = fs.Lookup("parser").Value.String()
+	}
+	if given["compdb"] {
+		// Relative to the working directory, not to the config
+		if cfg.CompDB, err = filepath.Abs(fs.Lookup("compdb").Value.String()); err != nil {
+			return nil, err
+		}
+	}
+	if given["D"] || given["U"] {
+		defines := *fs.Lookup("D").Value.(*listFlag)
+		undefines := *fs.Lookup("U").Value.(*listFlag)
+		cfg.Defines = append(without(cfg.Defines, undefines), defines...)
+		cfg.Undefines = append(without(cfg.Undefines, defines), undefines...)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(missing) > 0 {
+		if cfg.Depth <= 0 {
+			return nil, missingError(missing, usage)
+		}
+		fs.Set("depth", strconv.Itoa(cfg.Depth))
+	}
+
+	if raw != nil && !given["raw"] && !given["vim"] {
+		switch cfg.Format {
+		case "", "term":
+		case "raw":
+			*raw = true
+		case "vim":
+			vim = true
+		default:
+			return nil, &trace.OptionError{Option: "format in " + cfg.Path(), Value: cfg.Format}
+		}
+	}
+
+	if fs.Lookup("cache") != nil && !given["cache"] {
+		cache = cfg.Cache
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nishidy/rsb/trace"
+)
+
+// Exit codes of rsb, documented in README.
+const (
+	EXITERROR = 1 // I/O, cache or index failure
+	EXITUSAGE = 2 // Wrong arguments
+	EXITENTRY = 3 // The entry point or the target is not found
+)
+
+// usageError is a wrong command line, shown with the usage of the command.
+type usageError struct {
+	msg   string
+	usage string
+}
+
+func (e *usageError) Error() string {
+	return e.msg + "\nusage: " + e.usage
+}
+
+func exitCode(err error) int {
+	switch err.(type) {
+	case *usageError, *trace.OptionError:
+		return EXITUSAGE
+	case *trace.EntryError:
+		return EXITENTRY
+	}
+	return EXITERROR
+}
+
+// fail prints err to stderr, where it does not mix with the result read by
+// vim, and exits with the code for err.
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "rsb: "+err.Error())
+	os.Exit(exitCode(err))
+}
+
+func warn(err error) {
+	fmt.Fprintln(os.Stderr, "rsb: warning: "+err.Error())
+}
+
+func warnAll(errs []error) {
+	for _, err := range errs {
+		warn(err)
+	}
+}
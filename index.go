@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nishidy/rsb/trace"
+)
+
+const INDEXUSAGE = "rsb index [flags] ROOT"
+
+func runIndex(args []string) error {
+
+	dir := ""
+	show_status := false
+	show_parsers := false
+
+	fs := newFlagSet("index")
+	fs.StringVar(&dir, "root", "", "`ROOT` directory of the sources to index")
+	fs.BoolVar(&show_status, "status", false, "Count the fresh and stale files without updating the index")
+	fs.BoolVar(&show_parsers, "parsers", false, "List every file with the parser which parsed it")
+	vcsFlag(fs)
+	parserFlag(fs)
+	defineFlags(fs)
+
+	positionals, err := parseFlags(fs, INDEXUSAGE, args)
+	if err != nil {
+		return err
+	}
+	missing, err := fillPositionals(fs, INDEXUSAGE, positionals, "root")
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs, INDEXUSAGE, missing, nil)
+	if err != nil {
+		return err
+	}
+
+	store := trace.LoadIndexStore(dir, cfg)
+
+	if show_status {
+		status := store.Status()
+		warnAll(store.Warnings())
+		fmt.Printf("# Index of %s at %s\n", dir, store.Path())
+		fmt.Printf("fresh %d\nstale %d\nnew %d\nremoved %d\n",
+			status.Fresh, status.Stale, status.Added, status.Removed)
+		return nil
+	}
+
+	status, err := store.Update()
+	warnAll(store.Warnings())
+	if err != nil {
+		return fmt.Errorf("Cannot save the index: %s", err.Error())
+	}
+
+	fmt.Printf("# Indexed %d files under %s (%d re-parsed, %d removed).\n",
+		store.Len(), dir, status.Stale+status.Added, status.Removed)
+
+	if cfg.Parser == trace.PARSERCLANG || show_parsers {
+		printParsers(store, show_parsers)
+	}
+	return nil
+}
+
+// printParsers counts the files parsed by clang and those parsed raw, e.g.
+// not in the compilation database, and lists them when verbose.
+func printParsers(store *trace.IndexStore, verbose bool) {
+	parsers := store.Parsers()
+
+	counts := make(map[string]int)
+	files := []string{}
+	for rel, parser := range parsers {
+		counts[parser] += 1
+		files = append(files, rel)
+	}
+
+	compdb := store.CompDBPath()
+	if compdb == "" {
+		compdb = "none"
+	}
+	fmt.Printf("# Parsed %d files by clang and %d by raw (compdb %s).\n",
+		counts[trace.PARSERCLANG], counts[trace.PARSERRAW], compdb)
+
+	if !verbose {
+		return
+	}
+	sort.Strings(files)
+	for _, rel := range files {
+		fmt.Printf("%s %s\n", parsers[rel], rel)
+	}
+}
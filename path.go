@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nishidy/rsb/trace"
+)
+
+const PATHUSAGE = "rsb path [flags] FROM TO ROOT [DEPTH]"
+
+// runPath prints every call chain from the function FROM to TO as a tree
+// rooted at TO, which is a function name or FILE@LINE.
+func runPath(args []string) error {
+
+	var source string
+	var target string
+	opts := trace.Options{}
+	raw := false
+
+	fs := newFlagSet("path")
+	fs.StringVar(&source, "from", "", "Function `FROM` which the call paths start")
+	fs.StringVar(&target, "to", "", "Function or FILE@LINE `TO` which the call paths reach")
+	fs.StringVar(&opts.Dir, "root", "", "`ROOT` directory of the sources to search")
+	fs.IntVar(&opts.MaxLevel, "depth", 0, "Max `DEPTH` of the tree, mandatory unless set by "+trace.CONFIGFILE)
+	fs.StringVar(&opts.Sort, "sort", trace.SORTFILE, "Sort the tree by `KEY`, one of file, line, name and depth")
+	fs.StringVar(&opts.RefKinds, "ref-kinds", trace.DEFAULTREFKINDS, "Comma-separated `KINDS` of the references shown, of call, addr, decl and other")
+	vcsFlag(fs)
+	parserFlag(fs)
+	defineFlags(fs)
+	fs.BoolVar(&raw, "raw", false, "Print the tree without the interactive view")
+	fs.BoolVar(&vim, "vim", false, "Print the tree without colors for vim, implies --raw")
+
+	positionals, err := parseFlags(fs, PATHUSAGE, args)
+	if err != nil {
+		return err
+	}
+	missing, err := fillPositionals(fs, PATHUSAGE, positionals, "from", "to", "root", "depth")
+	if err != nil {
+		return err
+	}
+	if opts.Config, err = loadConfig(fs, PATHUSAGE, missing, &raw); err != nil {
+		return err
+	}
+
+	if vim {
+		raw = true
+	}
+
+	tree, err := trace.Paths(context.Background(), opts, source, target)
+	if err != nil {
+		return err
+	}
+	warnAll(tree.Warnings)
+
+	if len(tree.Root.Nodes()) == 0 {
+		fmt.Printf("# No call path from %s to %s within %d levels.\n", source, target, opts.MaxLevel)
+		return nil
+	}
+
+	shows := tree.Shows()
+
+	if !raw {
+		term := NewTerm(shows)
+		term.Run()
+	}
+
+	showResult(shows[1:])
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/nishidy/rsb/trace"
 	"github.com/nsf/termbox-go"
 )
 
@@ -15,14 +16,16 @@ type Term struct {
 	heads    []string
 	levels   []int
 	showHead bool
+	title    string
 }
 
-func NewTerm(shows ShowsInfo) Term {
-	term := Term{0, 0, []string{}, []string{}, []int{}, false}
+func NewTerm(shows trace.ShowsInfo) Term {
+	title := "# Available keys: vim[enter] up[↓/C-j] down[↑/C-k] head[C-h] bottom[C-b] quit[Esc/C-q] header[space]"
+	term := Term{0, 0, []string{}, []string{}, []int{}, false, title}
 	for _, show := range shows[1:] {
-		term.strs = append(term.strs, show.result)
-		term.heads = append(term.heads, show.head)
-		term.levels = append(term.levels, show.level)
+		term.strs = append(term.strs, show.Result)
+		term.heads = append(term.heads, show.Head)
+		term.levels = append(term.levels, show.Level)
 	}
 
 	return term
@@ -109,8 +112,7 @@ func drawALine(str_raw string, bgAttr termbox.Attribute, y int) {
 func (t *Term) draw() {
 	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 
-	exp := "# Available keys: vim[enter] up[↓/C-j] down[↑/C-k] head[C-h] bottom[C-b] quit[Esc/C-q] header[space]"
-	drawTitle(exp, termbox.ColorDefault, 0)
+	drawTitle(t.title, termbox.ColorDefault, 0)
 
 	show_head := 0
 	for y, str := range t.strs[t.ybase:] {
@@ -132,6 +134,67 @@ func (t *Term) draw() {
 	termbox.Flush()
 }
 
+func (t *Term) down() {
+	if t.yabs < len(t.strs)-1 {
+		t.yabs += 1
+		_, height := termbox.Size()
+		// 1 : index which starts from 0
+		// 2 : the first line is title
+		height -= 2
+		if t.showHead {
+			height -= 1
+		}
+		if height < t.yabs-t.ybase {
+			t.ybase += 1
+		}
+	}
+}
+
+func (t *Term) up() {
+	if t.yabs > 0 {
+		t.yabs -= 1
+		if t.yabs < t.ybase {
+			t.ybase = t.yabs
+		}
+	}
+}
+
+// NewPicker shows items to choose one of them with Pick.
+func NewPicker(items []string) Term {
+	title := "# Choose one: select[enter] up[↓/C-j] down[↑/C-k] all[a/Esc/C-q]"
+	term := Term{0, 0, []string{}, []string{}, []int{}, false, title}
+	for _, item := range items {
+		term.strs = append(term.strs, item)
+		term.heads = append(term.heads, "")
+		term.levels = append(term.levels, 1)
+	}
+	return term
+}
+
+// Pick returns the index of the item chosen, or -1 for all of them.
+func (t *Term) Pick() int {
+
+	_ = termbox.Init()
+	defer termbox.Close()
+
+	t.draw()
+	for {
+		if ev := termbox.PollEvent(); ev.Type == termbox.EventKey {
+			switch {
+			case ev.Key == termbox.KeyEnter:
+				return t.yabs
+			case ev.Key == termbox.KeyEsc, ev.Key == termbox.KeyCtrlQ, ev.Ch == 'a':
+				return -1
+			case ev.Key == termbox.KeyArrowDown, ev.Key == termbox.KeyCtrlJ:
+				t.down()
+			case ev.Key == termbox.KeyArrowUp, ev.Key == termbox.KeyCtrlK:
+				t.up()
+			}
+		}
+		t.draw()
+	}
+}
+
 func (t *Term) Run() {
 
 	_ = termbox.Init()
@@ -147,27 +210,10 @@ func (t *Term) Run() {
 				return
 			case termbox.KeyArrowDown,
 				termbox.KeyCtrlJ:
-				if t.yabs < len(t.strs)-1 {
-					t.yabs += 1
-					_, height := termbox.Size()
-					// 1 : index which starts from 0
-					// 2 : the first line is title
-					height -= 2
-					if t.showHead {
-						height -= 1
-					}
-					if height < t.yabs-t.ybase {
-						t.ybase += 1
-					}
-				}
+				t.down()
 			case termbox.KeyArrowUp,
 				termbox.KeyCtrlK:
-				if t.yabs > 0 {
-					t.yabs -= 1
-					if t.yabs < t.ybase {
-						t.ybase = t.yabs
-					}
-				}
+				t.up()
 			case termbox.KeyCtrlH:
 				t.yabs = 0
 				t.ybase = 0
@@ -0,0 +1,169 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Options of a search. Dir, Entry or Func, and MaxLevel are mandatory.
+type Options struct {
+	Dir      string
+	Entry    Entry
+	Func     string // Every definition of the function is an entry point instead of Entry
+	Kind     string // Search the uses of Symbol, which is KINDSTRUCT, KINDGLOBAL or KINDMACRO
+	Symbol   string
+	MaxLevel int
+	Forward  bool          // List callees instead of callers
+	Jobs     int           // The number of CPUs when 0
+	Timeout  time.Duration // For the search only, not for building the index
+	Sort     string        // SORTFILE when empty
+	RefKinds string        // Kinds of the references shown as callers, DEFAULTREFKINDS when empty
+	Config   *Config       // The files to search, all .c and .h files under Dir when nil
+	Index    *Index        // Built from Dir and Config when nil, e.g. the one of FindFunc
+
+	// OnEntry is called with the function at the entry point before the
+	// search starts
+	OnEntry func(file, fun string)
+}
+
+// Tree is the result of a search. The nodes of Root are the entry points.
+type Tree struct {
+	Root      *Trace
+	Truncated bool    // Some nodes were not searched because of the timeout
+	Warnings  []error // Problems which did not stop the search
+}
+
+// Shows flattens the tree in the order to show, starting with Root.
+func (tree *Tree) Shows() ShowsInfo {
+	shows := ShowsInfo{}
+	DownTree(tree.Root, &shows)
+	return shows
+}
+
+// newSession builds the index of opts.Dir. The pool is left to the caller
+// so that a timeout does not count the time for the index.
+func newSession(opts Options) (*session, error) {
+
+	if opts.Sort != "" && !isSortKey(opts.Sort) {
+		return nil, &OptionError{"sort key", opts.Sort}
+	}
+	if err := opts.Config.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Kind != "" && !isKind(opts.Kind) {
+		return nil, &OptionError{"kind", opts.Kind}
+	}
+	if opts.Kind != "" && opts.Forward {
+		return nil, &OptionError{"kind with forward", opts.Kind}
+	}
+	if opts.RefKinds == "" {
+		opts.RefKinds = DEFAULTREFKINDS
+	}
+	refkinds, err := ParseRefKinds(opts.RefKinds)
+	if err != nil {
+		return nil, err
+	}
+
+	index := opts.Index
+	if index == nil {
+		index = BuildIndex(opts.Dir, opts.Config)
+	}
+
+	s := &session{opts.Dir, opts.MaxLevel, opts.Forward, refkinds, nil, new(sync.Mutex), index,
+		make(map[string]*Trace), opts.OnEntry}
+
+	return s, nil
+}
+
+// entries are the entry points of opts, which must be inside some scope.
+func (s *session) entries(opts Options) ([]Entry, error) {
+
+	if opts.Func != "" {
+		entries := []Entry{}
+		for _, def := range s.index.Functions(opts.Func) {
+			entries = append(entries, Entry{def.file, def.line})
+		}
+		if len(entries) == 0 {
+			return nil, &EntryError{opts.Func, "is not defined in any source file"}
+		}
+		return entries, nil
+	}
+
+	entry := fmt.Sprintf("%s@L%d", opts.Entry.File, opts.Entry.Line)
+	decls, ok := s.index.decls[opts.Entry.File]
+	if !ok {
+		return nil, &EntryError{entry, "is not a source file under " + opts.Dir}
+	}
+	if findDecl(decls, opts.Entry.Line) < 0 {
+		return nil, &EntryError{entry, "is not in any function or struct"}
+	}
+	return []Entry{opts.Entry}, nil
+}
+
+// FindFunc returns where opts.Func is defined, e.g. to choose one of them as
+// Entry before Backtrace, and the index to pass to it as Options.Index.
+func FindFunc(opts Options) ([]Entry, *Index, error) {
+	s, err := newSession(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := s.entries(opts)
+	return entries, s.index, err
+}
+
+func (opts Options) jobs() int {
+	if opts.Jobs > 0 {
+		return opts.Jobs
+	}
+	return runtime.NumCPU()
+}
+
+// Backtrace searches the callers of the function at opts.Entry recursively,
+// or the callees with opts.Forward.
+func Backtrace(ctx context.Context, opts Options) (*Tree, error) {
+
+	s, err := newSession(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	s.pool = newWorkerPool(ctx, opts.jobs())
+
+	var root *Trace
+
+	if opts.Kind != "" {
+		ent := fmt.Sprintf("Go search the uses of %s %s.\n", opts.Kind, opts.Symbol)
+		root = &Trace{s, Entry{}, Callee{}, 1, ent, nil, nil, nil}
+
+		root.readUses(newSymbol(opts.Kind, opts.Symbol))
+
+	} else {
+		entries, err := s.entries(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		ent := fmt.Sprintf("Go search from this entry point %s@L%d.\n", opts.Entry.File, opts.Entry.Line)
+		if opts.Func != "" {
+			ent = fmt.Sprintf("Go search from every definition of %s.\n", opts.Func)
+		}
+		root = &Trace{s, opts.Entry, Callee{}, 1, ent, nil, nil, nil}
+
+		for _, entry := range entries {
+			root.read1stFunc(entry)
+		}
+	}
+	s.pool.Wait()
+
+	sortTree(root, opts.Sort)
+
+	return &Tree{root, s.pool.Truncated(), s.index.Warnings()}, nil
+}
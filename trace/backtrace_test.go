@@ -0,0 +1,257 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixture tree is searched with several workers many times, which is
+// meant to be run with go test -race.
+func searchFixture(t *testing.T, opts Options) string {
+
+	home, _ := ioutil.TempDir("", "rsb-home")
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	opts.Dir = filepath.Join("testdata", "tree")
+	if opts.Entry.File != "" {
+		opts.Entry.File = filepath.Join(opts.Dir, opts.Entry.File)
+	}
+	opts.Jobs = 8
+
+	tree, err := Backtrace(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	no_ansi := strings.NewReplacer("\x1b[34m", "", "\x1b[31m", "", "\x1b[0m", "")
+
+	result := ""
+	for _, show := range tree.Shows()[1:] {
+		result += no_ansi.Replace(show.Result)
+	}
+	return result
+}
+
+func TestSearchBacktrace(t *testing.T) {
+
+	expected := `-1- Entry point testdata/tree/src/buf.c@L22 in release function scope.
+ -2- release (call) defined in testdata/tree/include/buf.h@L8.
+ -2- release (call) testdata/tree/src/buf.c@L5 in free_buffer function scope.
+  -3- free_buffer (call) testdata/tree/src/buf.c@L10 in process function scope.
+   -4- process (call) testdata/tree/src/main.c@L5 in main function scope.
+   -4- process (call) testdata/tree/src/net/packet.c@L14 in handle_packet function scope.
+    -5- handle_packet (call) testdata/tree/src/main.c@L4 in main function scope.
+  -3- free_buffer (call) testdata/tree/src/buf.c@L11 in process function scope.
+  -3- free_buffer (call) testdata/tree/src/net/packet.c@L11 in handle_packet function scope. ` + "↑" + ` see above
+`
+
+	for i := 0; i < 20; i++ {
+		if result := searchFixture(t, Options{Entry: Entry{"src/buf.c", 22}, MaxLevel: 6}); result != expected {
+			t.Fatalf("Unexpected backtrace:\n%s", result)
+		}
+	}
+}
+
+func TestSearchForwardCycle(t *testing.T) {
+
+	expected := `-1- Entry point testdata/tree/src/main.c@L11 in loop_a function scope.
+ -2- loop_a testdata/tree/src/main.c@L11 calls loop_b defined in testdata/tree/src/main.c@L17.
+  -3- loop_b testdata/tree/src/main.c@L16 calls loop_a defined in testdata/tree/src/main.c@L12. ` + "↺" + ` already on path
+`
+
+	for i := 0; i < 20; i++ {
+		if result := searchFixture(t, Options{Entry: Entry{"src/main.c", 11}, MaxLevel: 6, Forward: true}); result != expected {
+			t.Fatalf("Unexpected forward trace:\n%s", result)
+		}
+	}
+}
+
+func TestSearchFunc(t *testing.T) {
+
+	// Both static functions named dump are roots
+	expected := `-1- Entry point testdata/tree/src/main.c@L22 in dump function scope.
+ -2- dump testdata/tree/src/main.c@L21 calls loop_b defined in testdata/tree/src/main.c@L17.
+-1- Entry point testdata/tree/src/net/packet.c@L21 in dump function scope.
+ -2- dump testdata/tree/src/net/packet.c@L20 calls check_len defined in testdata/tree/src/buf.c@L17.
+`
+
+	if result := searchFixture(t, Options{Func: "dump", MaxLevel: 2, Forward: true}); result != expected {
+		t.Fatalf("Unexpected trace from dump:\n%s", result)
+	}
+}
+
+func TestSearchUses(t *testing.T) {
+
+	expected := `-1- global alloc_count defined in testdata/tree/src/buf.c@L26.
+ -2- alloc_count (write) testdata/tree/src/buf.c@L32 in count_alloc function scope.
+  -3- count_alloc (call) testdata/tree/src/net/packet.c@L25 in new_packet function scope.
+ -2- alloc_count (read) testdata/tree/src/buf.c@L37 in over_max function scope.
+  -3- over_max (call) testdata/tree/src/net/packet.c@L26 in new_packet function scope.
+`
+
+	if result := searchFixture(t, Options{Kind: KINDGLOBAL, Symbol: "alloc_count", MaxLevel: 3}); result != expected {
+		t.Fatalf("Unexpected uses of alloc_count:\n%s", result)
+	}
+
+	expected = `-1- struct buffer defined in testdata/tree/include/buf.h@L1.
+ -2- buffer testdata/tree/include/buf.h@L2 in buffer struct scope.
+ -2- buffer testdata/tree/include/buf.h@L5 in buf_reset function scope.
+ -2- buffer testdata/tree/src/buf.c@L3 in free_buffer function scope.
+ -2- buffer testdata/tree/src/buf.c@L19 in release function scope.
+`
+
+	if result := searchFixture(t, Options{Kind: KINDSTRUCT, Symbol: "buffer", MaxLevel: 1}); result != expected {
+		t.Fatalf("Unexpected uses of struct buffer:\n%s", result)
+	}
+}
+
+func TestSearchIndirect(t *testing.T) {
+
+	expected := `-1- Entry point testdata/tree/src/dev.c@L11 in my_read function scope.
+ -2- my_read (addr) testdata/tree/src/dev.c@L15 in my_ops struct scope.
+ -2- my_read (call) testdata/tree/src/dev.c@L20 in dev_read function scope. indirect via .read
+ -2- my_read (addr) testdata/tree/src/dev.c@L25 in dev_poll function scope.
+ -2- my_read (call) testdata/tree/src/dev.c@L26 in dev_poll function scope. indirect via poll
+`
+
+	if result := searchFixture(t, Options{Entry: Entry{"src/dev.c", 11}, MaxLevel: 2}); result != expected {
+		t.Fatalf("Unexpected callers of my_read:\n%s", result)
+	}
+}
+
+func TestSearchStatic(t *testing.T) {
+
+	// The dump of packet.c is another function
+	expected := `-1- Entry point testdata/tree/src/main.c@L21 in dump function scope.
+ -2- dump (call) testdata/tree/src/main.c@L26 in debug function scope.
+`
+
+	if result := searchFixture(t, Options{Entry: Entry{"src/main.c", 21}, MaxLevel: 2}); result != expected {
+		t.Fatalf("Unexpected callers of the static dump:\n%s", result)
+	}
+}
+
+func TestSearchRefKinds(t *testing.T) {
+
+	expected := `-1- Entry point testdata/tree/src/dev.c@L11 in my_read function scope.
+ -2- my_read (call) testdata/tree/src/dev.c@L20 in dev_read function scope. indirect via .read
+ -2- my_read (call) testdata/tree/src/dev.c@L26 in dev_poll function scope. indirect via poll
+`
+
+	if result := searchFixture(t, Options{Entry: Entry{"src/dev.c", 11}, MaxLevel: 2, RefKinds: "call"}); result != expected {
+		t.Fatalf("Unexpected calls of my_read:\n%s", result)
+	}
+}
+
+func TestSearchCxx(t *testing.T) {
+
+	// The overloads are not told apart by the arguments
+	expected := `-1- Entry point testdata/tree/src/shape.cpp@L17 in geo::Shape::scale(int x) function scope.
+ -2- geo::Shape::scale (call) testdata/tree/src/shape.cpp@L7 in geo::Shape::area function scope. ? ambiguous, 2 definitions
+ -2- geo::Shape::scale (call) testdata/tree/src/shape.cpp@L22 in geo::Shape::scale(double x) function scope. ? ambiguous, 2 definitions
+`
+
+	if result := searchFixture(t, Options{Entry: Entry{"src/shape.cpp", 17}, MaxLevel: 2}); result != expected {
+		t.Fatalf("Unexpected callers of Shape::scale:\n%s", result)
+	}
+}
+
+func TestPaths(t *testing.T) {
+
+	home, _ := ioutil.TempDir("", "rsb-home")
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	opts := Options{Dir: filepath.Join("testdata", "tree"), MaxLevel: 5}
+
+	tree, err := Paths(context.Background(), opts, "main", "release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	no_ansi := strings.NewReplacer("\x1b[34m", "", "\x1b[31m", "", "\x1b[0m", "")
+	result := ""
+	for _, show := range tree.Shows()[1:] {
+		result += no_ansi.Replace(show.Result)
+	}
+
+	expected := `-1- Target testdata/tree/src/buf.c@L24 in release function scope.
+ -2- release (call) testdata/tree/src/buf.c@L5 in free_buffer function scope.
+  -3- free_buffer (call) testdata/tree/src/buf.c@L10 in process function scope.
+   -4- process (call) testdata/tree/src/main.c@L5 in main function scope.
+   -4- process (call) testdata/tree/src/net/packet.c@L14 in handle_packet function scope.
+    -5- handle_packet (call) testdata/tree/src/main.c@L4 in main function scope.
+  -3- free_buffer (call) testdata/tree/src/net/packet.c@L11 in handle_packet function scope.
+   -4- handle_packet (call) testdata/tree/src/main.c@L4 in main function scope.
+`
+
+	if result != expected || tree.Truncated {
+		t.Fatalf("Unexpected paths from main to release:\n%s", result)
+	}
+
+	// A search cancelled before it starts is truncated
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if tree, err = Paths(ctx, opts, "main", "release"); err != nil || !tree.Truncated {
+		t.Fatalf("Cancelled search is not truncated: %v", err)
+	}
+}
+
+func TestFindFuncIndex(t *testing.T) {
+
+	home, _ := ioutil.TempDir("", "rsb-home")
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	opts := Options{Dir: filepath.Join("testdata", "tree"), Func: "dump", MaxLevel: 2}
+
+	entries, index, err := FindFunc(opts)
+	if err != nil || len(entries) != 2 || index == nil {
+		t.Fatalf("Unexpected definitions of dump: %v %v", entries, err)
+	}
+
+	// The index is taken as it is, without reading Dir again
+	opts.Index = index
+	opts.Dir = filepath.Join("testdata", "missing")
+	tree, err := Backtrace(context.Background(), opts)
+	if err != nil || len(tree.Root.Nodes()) != 2 {
+		t.Fatalf("The index of FindFunc is not searched: %v", err)
+	}
+}
+
+func TestEntryScope(t *testing.T) {
+
+	home, _ := ioutil.TempDir("", "rsb-home")
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	dir := filepath.Join("testdata", "tree")
+	buf := filepath.Join(dir, "src", "buf.c")
+
+	// A blank line between two functions and a global are at file scope
+	for _, line := range []uint32{7, 13, 26} {
+		opts := Options{Dir: dir, Entry: Entry{buf, line}, MaxLevel: 2}
+		if _, err := Backtrace(context.Background(), opts); err == nil {
+			t.Errorf("%s@L%d is traced as a function.", buf, line)
+		}
+		if _, err := Paths(context.Background(), opts, "main", fmt.Sprintf("%s@L%d", buf, line)); err == nil {
+			t.Errorf("%s@L%d is searched as a function.", buf, line)
+		}
+	}
+
+	// The head of a function is in it
+	opts := Options{Dir: dir, Entry: Entry{buf, 8}, MaxLevel: 2}
+	if _, err := Backtrace(context.Background(), opts); err != nil {
+		t.Errorf("The head of process is not traced: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package trace
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	COMPDBFILE = "compile_commands.json"
+)
+
+// compileCommand is one entry of a compilation database, which has either
+// the arguments or the whole command line.
+type compileCommand struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Arguments []string `json:"arguments"`
+	Command   string   `json:"command"`
+}
+
+// compDB maps the absolute path of each file to the flags clang parses it
+// with, i.e. the arguments without the compiler, the output and the file.
+type compDB map[string][]string
+
+// compdb is the compilation database configured, or COMPDBFILE in dir when
+// it exists. It is "" when there is none.
+func (cfg *Config) compdb(dir string) string {
+	if cfg != nil && cfg.CompDB != "" {
+		if filepath.IsAbs(cfg.CompDB) {
+			return cfg.CompDB
+		}
+		return filepath.Join(cfg.base(dir), cfg.CompDB)
+	}
+	path := filepath.Join(dir, COMPDBFILE)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+func loadCompDB(path string) (compDB, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := []compileCommand{}
+	if err := json.Unmarshal(body, &commands); err != nil {
+		return nil, &ParseError{path, 0, err.Error()}
+	}
+
+	db := make(compDB)
+	for _, cmd := range commands {
+		file := cmd.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(cmd.Directory, file)
+		}
+		args := cmd.Arguments
+		if len(args) == 0 {
+			args = splitCommand(cmd.Command)
+		}
+		db[filepath.Clean(file)] = clangFlags(args, cmd.Directory, file)
+	}
+	return db, nil
+}
+
+// flags returns the flags of path and whether path is in the database.
+func (db compDB) flags(path string) ([]string, bool) {
+	abs_path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, false
+	}
+	flags, ok := db[abs_path]
+	return flags, ok
+}
+
+// splitCommand splits a command line like sh, with quotes and backslashes.
+func splitCommand(command string) []string {
+	args := []string{}
+	arg := []rune{}
+	in_arg := false
+	quote := rune(0)
+	escaped := false
+
+	for _, c := range command {
+		switch {
+		case escaped:
+			arg = append(arg, c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			in_arg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg = append(arg, c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			in_arg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if in_arg {
+				args = append(args, string(arg))
+				arg = arg[:0]
+				in_arg = false
+			}
+		default:
+			arg = append(arg, c)
+			in_arg = true
+		}
+	}
+	if in_arg {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+// Flags followed by a path which is relative to the directory of the entry.
+var compdbPathFlags = []string{"-I", "-isystem", "-iquote", "-idirafter", "-include", "-imacros", "--sysroot", "-isysroot"}
+
+// Flags which only tell where to write, dropped with their value.
+var compdbOutputFlags = []string{"-o", "-MF", "-MT", "-MQ"}
+
+// clangFlags drops the compiler, the file and the output of args, and makes
+// the paths absolute since clang parses in the directory of rsb.
+func clangFlags(args []string, dir, file string) []string {
+	flags := []string{}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "-c" || arg == "-MD" || arg == "-MMD":
+			continue
+		case filepath.Clean(arg) == filepath.Clean(file) || filepath.Join(dir, arg) == filepath.Clean(file):
+			continue
+		case hasFlag(compdbOutputFlags, arg):
+			i += 1
+			continue
+		}
+
+		if hasFlag(compdbPathFlags, arg) && i+1 < len(args) {
+			flags = append(flags, arg, absPath(dir, args[i+1]))
+			i += 1
+			continue
+		}
+		if value := strings.TrimPrefix(arg, "-I"); value != arg {
+			arg = "-I" + absPath(dir, value)
+		}
+		flags = append(flags, arg)
+	}
+
+	return flags
+}
+
+func hasFlag(flags []string, arg string) bool {
+	for _, flag := range flags {
+		if arg == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func absPath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
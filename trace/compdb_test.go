@@ -0,0 +1,56 @@
+package trace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+
+	args := splitCommand(`cc -DNAME="a b" -I'inc dir' -DQ=\"x\"  -c a.c`)
+	expected := []string{"cc", "-DNAME=a b", "-Iinc dir", `-DQ="x"`, "-c", "a.c"}
+
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Args are %q.", args)
+	}
+
+}
+
+func TestLoadCompDB(t *testing.T) {
+
+	root, _ := ioutil.TempDir("", "rsb-root")
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, COMPDBFILE)
+	ioutil.WriteFile(path, []byte(`[
+  {"directory": "`+root+`", "file": "src/a.c",
+   "command": "gcc -Iinclude -I /usr/include/x -DLEVEL=2 -o build/a.o -c src/a.c"},
+  {"directory": "`+root+`/src", "file": "b.c",
+   "arguments": ["clang", "-isystem", "../sys", "-MD", "-MF", "b.d", "-c", "b.c"]}
+]`), 0644)
+
+	db, err := loadCompDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags, ok := db.flags(filepath.Join(root, "src/a.c"))
+	expected := []string{"-I" + filepath.Join(root, "include"), "-I", "/usr/include/x", "-DLEVEL=2"}
+	if !ok || !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Flags of a.c are %q.", flags)
+	}
+
+	flags, ok = db.flags(filepath.Join(root, "src/b.c"))
+	expected = []string{"-isystem", filepath.Join(root, "sys")}
+	if !ok || !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Flags of b.c are %q.", flags)
+	}
+
+	if _, ok := db.flags(filepath.Join(root, "src/c.c")); ok {
+		t.Errorf("c.c is not in the database.")
+	}
+
+}
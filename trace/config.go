@@ -0,0 +1,224 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	CONFIGFILE = ".rsb.toml"
+)
+
+// Config is the project configuration read from CONFIGFILE. Roots and the
+// globs are relative to the directory of the file.
+//
+//	roots = ["src", "lib"]
+//	extensions = ["c", "h", "cc", "inc"]
+//	include = ["src/**"]
+//	exclude = ["build/**", "third_party/**", "*_test.c"]
+//	depth = 4
+//	format = "vim"
+//	cache = true
+//	vcs = "tracked"
+//	parser = "clang"
+//	compdb = "build/compile_commands.json"
+//	defines = ["CONFIG_NET", "LEVEL=2"]
+//	undefines = ["DEBUG"]
+type Config struct {
+	Roots      []string `toml:"roots"`
+	Extensions []string `toml:"extensions"`
+	Include    []string `toml:"include"`
+	Exclude    []string `toml:"exclude"`
+	Depth      int      `toml:"depth"`
+	Format     string   `toml:"format"` // "term", "raw" or "vim"
+	Cache      bool     `toml:"cache"`
+	VCS        string   `toml:"vcs"`    // One of the VCS modes, VCSAUTO by default
+	Parser     string   `toml:"parser"` // PARSERRAW by default
+	CompDB     string   `toml:"compdb"` // COMPDBFILE in the root by default
+	Defines    []string `toml:"defines"`
+	Undefines  []string `toml:"undefines"`
+
+	path string
+}
+
+var defaultExtensions = []string{"c", "h", "cpp", "cc", "cxx", "hpp", "hh", "hxx"}
+
+// FindConfig reads the first CONFIGFILE found from dir up to the root of the
+// file system. An empty Config is returned when there is none.
+func FindConfig(dir string) (*Config, error) {
+	cfg := &Config{}
+
+	abs_dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for d := abs_dir; ; d = filepath.Dir(d) {
+		path := filepath.Join(d, CONFIGFILE)
+		if _, err := os.Stat(path); err == nil {
+			if _, err := toml.DecodeFile(path, cfg); err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err.Error())
+			}
+			// Keep the path relative like dir so that it is joined to the roots
+			cfg.path = path
+			if wd, err := os.Getwd(); err == nil && !filepath.IsAbs(dir) {
+				if rel, err := filepath.Rel(wd, path); err == nil {
+					cfg.path = rel
+				}
+			}
+			return cfg, nil
+		}
+		if d == filepath.Dir(d) {
+			return cfg, nil
+		}
+	}
+}
+
+// Validate checks the values used by the search.
+func (cfg *Config) Validate() error {
+	if mode := cfg.vcs(); !isVCSMode(mode) {
+		return &OptionError{"vcs", mode}
+	}
+	if parser := cfg.parser(); !isParser(parser) {
+		return &OptionError{"parser", parser}
+	}
+	return nil
+}
+
+// Path is the file the config was read from, or "" for the default one.
+func (cfg *Config) Path() string {
+	if cfg == nil {
+		return ""
+	}
+	return cfg.path
+}
+
+// base is the directory the roots and the globs are relative to.
+func (cfg *Config) base(dir string) string {
+	if cfg.Path() == "" {
+		return dir
+	}
+	return filepath.Dir(cfg.path)
+}
+
+// roots are the directories to walk, dir itself unless configured.
+func (cfg *Config) roots(dir string) []string {
+	if cfg == nil || len(cfg.Roots) == 0 {
+		return []string{dir}
+	}
+	roots := []string{}
+	for _, root := range cfg.Roots {
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(cfg.base(dir), root)
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+// defines are given to the preprocessor, where an undefined name is marked
+// with "!".
+func (cfg *Config) defines() []string {
+	if cfg == nil {
+		return nil
+	}
+	defines := append([]string{}, cfg.Defines...)
+	for _, name := range cfg.Undefines {
+		defines = append(defines, "!"+name)
+	}
+	return defines
+}
+
+func (cfg *Config) extensions() []string {
+	if cfg == nil || len(cfg.Extensions) == 0 {
+		return defaultExtensions
+	}
+	return cfg.Extensions
+}
+
+// isSourceFile tells whether path is one of the extensions and not
+// excluded. Dot-files are never sources.
+func (cfg *Config) isSourceFile(dir, path string) bool {
+	file := filepath.Base(path)
+
+	if strings.HasPrefix(file, ".") {
+		return false
+	}
+
+	ok := false
+	for _, ext := range cfg.extensions() {
+		if filepath.Ext(file) == "."+strings.TrimPrefix(ext, ".") {
+			ok = true
+			break
+		}
+	}
+	if !ok || cfg.isExcluded(dir, path) {
+		return false
+	}
+
+	if cfg == nil || len(cfg.Include) == 0 {
+		return true
+	}
+	return matchAny(cfg.Include, cfg.rel(dir, path))
+}
+
+// isExcluded is also used for directories, so that "build/**" skips the
+// whole build directory.
+func (cfg *Config) isExcluded(dir, path string) bool {
+	if cfg == nil {
+		return false
+	}
+	return matchAny(cfg.Exclude, cfg.rel(dir, path))
+}
+
+func (cfg *Config) rel(dir, path string) string {
+	rel, err := filepath.Rel(cfg.base(dir), path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches rel with a glob where "**" is any number of directories.
+// A pattern without "/" is matched with the file name only.
+func matchGlob(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := filepath.Match(pattern, filepath.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, rel []string) bool {
+	if len(pattern) == 0 {
+		return len(rel) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(rel); i++ {
+			if matchSegments(pattern[1:], rel[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(rel) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], rel[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], rel[1:])
+}
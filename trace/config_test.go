@@ -0,0 +1,67 @@
+package trace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+
+	cases := []struct {
+		pattern string
+		rel     string
+		match   bool
+	}{
+		{"build/**", "build", true},
+		{"build/**", "build/gen/a.c", true},
+		{"build/**", "src/build/a.c", false},
+		{"**/gen/*.c", "src/gen/a.c", true},
+		{"**/gen/*.c", "gen/a.c", true},
+		{"*_test.c", "src/buf_test.c", true},
+		{"src/*.c", "src/net/packet.c", false},
+	}
+
+	for _, c := range cases {
+		if matchGlob(c.pattern, c.rel) != c.match {
+			t.Errorf("%s with %s failed.", c.pattern, c.rel)
+		}
+	}
+
+}
+
+func TestConfigWalk(t *testing.T) {
+
+	root, _ := ioutil.TempDir("", "rsb-root")
+	defer os.RemoveAll(root)
+
+	for _, file := range []string{"src/a.c", "src/b.cc", "src/c.inc", "build/d.c", "vendor/e.c"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, file)), 0755)
+		ioutil.WriteFile(filepath.Join(root, file), []byte(""), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(root, CONFIGFILE), []byte(`
+roots = ["src", "build"]
+extensions = ["c", "cc"]
+exclude = ["build/**"]
+`), 0644)
+
+	cfg, err := FindConfig(filepath.Join(root, "src"))
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	files := []string{}
+	store := &IndexStore{INDEXVERSION, root, "", nil, "", make(map[string]*FileRecord), "", cfg, "", nil}
+	store.walk(func(path, rel string, info os.FileInfo) {
+		files = append(files, rel)
+	})
+	sort.Strings(files)
+
+	if !reflect.DeepEqual(files, []string{"src/a.c", "src/b.cc"}) {
+		t.Errorf("Unexpected files: %v", files)
+	}
+
+}
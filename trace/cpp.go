@@ -0,0 +1,395 @@
+package trace
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// macro is a macro known to the preprocessor. A macro which is neither
+// defined nor undefined is unknown, e.g. defined in a header not parsed.
+type macro struct {
+	value   string
+	defined bool
+}
+
+type branch struct {
+	active bool // Lines in the branch are parsed
+	taken  bool // A branch of the #if was already parsed
+	outer  bool // The #if itself is in an active branch
+}
+
+// preprocessor follows the conditional blocks of one file line by line, so
+// that only one branch of each #if is parsed. A condition which cannot be
+// evaluated takes its first branch, as the branches usually differ only in
+// what they open and close.
+type preprocessor struct {
+	macros    map[string]macro
+	branches  []branch
+	directive string // A directive continued with a backslash
+	continued bool
+}
+
+var re_directive = regexp.MustCompile(`^\s*#\s*(\w*)\s*(.*)$`)
+
+// newPreprocessor takes defines like the -D and -U options of cc, i.e.
+// "NAME", "NAME=VALUE" or "!NAME" for undefined.
+func newPreprocessor(defines []string) *preprocessor {
+	pp := &preprocessor{make(map[string]macro), nil, "", false}
+	for _, define := range defines {
+		if strings.HasPrefix(define, "!") {
+			pp.macros[define[1:]] = macro{"", false}
+			continue
+		}
+		name, value := define, "1"
+		if i := strings.Index(define, "="); i >= 0 {
+			name, value = define[:i], define[i+1:]
+		}
+		pp.macros[name] = macro{value, true}
+	}
+	return pp
+}
+
+func (pp *preprocessor) active() bool {
+	if len(pp.branches) == 0 {
+		return true
+	}
+	return pp.branches[len(pp.branches)-1].active
+}
+
+// line takes a line without comments and tells whether it is code to parse,
+// i.e. neither a directive, continued or not, nor in a branch not parsed.
+func (pp *preprocessor) line(ln string) bool {
+
+	if pp.continued {
+		pp.directive += " " + strings.TrimSuffix(ln, "\\")
+		pp.continued = strings.HasSuffix(ln, "\\")
+		if !pp.continued {
+			pp.run(pp.directive)
+		}
+		return false
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(ln), "#") {
+		return pp.active()
+	}
+
+	if strings.HasSuffix(ln, "\\") {
+		pp.directive = strings.TrimSuffix(ln, "\\")
+		pp.continued = true
+		return false
+	}
+
+	pp.run(ln)
+	return false
+}
+
+func (pp *preprocessor) run(directive string) {
+
+	match := re_directive.FindStringSubmatch(directive)
+	if match == nil {
+		return
+	}
+	name, arg := match[1], strings.TrimSpace(match[2])
+
+	switch name {
+	case "if", "ifdef", "ifndef":
+		outer := pp.active()
+		value, known := pp.condition(name, arg)
+		active := outer && (!known || value != 0)
+		pp.branches = append(pp.branches, branch{active, active || !known, outer})
+
+	case "elif":
+		if len(pp.branches) == 0 {
+			return
+		}
+		b := &pp.branches[len(pp.branches)-1]
+		if b.taken {
+			b.active = false
+			return
+		}
+		value, known := pp.eval(arg)
+		b.active = b.outer && (!known || value != 0)
+		b.taken = b.active || !known
+
+	case "else":
+		if len(pp.branches) == 0 {
+			return
+		}
+		b := &pp.branches[len(pp.branches)-1]
+		b.active = b.outer && !b.taken
+		b.taken = true
+
+	case "endif":
+		if len(pp.branches) > 0 {
+			pp.branches = pp.branches[:len(pp.branches)-1]
+		}
+
+	case "define":
+		if !pp.active() {
+			return
+		}
+		fields := strings.SplitN(arg, " ", 2)
+		macro_name := fields[0]
+		value := ""
+		if i := strings.Index(macro_name, "("); i >= 0 {
+			// A function-like macro has no value to evaluate
+			macro_name = macro_name[:i]
+			value = "("
+		} else if len(fields) > 1 {
+			value = strings.TrimSpace(fields[1])
+		}
+		pp.macros[macro_name] = macro{value, true}
+
+	case "undef":
+		if pp.active() {
+			pp.macros[arg] = macro{"", false}
+		}
+	}
+}
+
+func (pp *preprocessor) condition(name, arg string) (int64, bool) {
+	switch name {
+	case "ifdef":
+		return pp.defined(arg)
+	case "ifndef":
+		value, known := pp.defined(arg)
+		return 1 - value, known
+	}
+	return pp.eval(arg)
+}
+
+func (pp *preprocessor) defined(name string) (int64, bool) {
+	m, ok := pp.macros[strings.TrimSpace(name)]
+	if !ok {
+		return 0, false
+	}
+	if m.defined {
+		return 1, true
+	}
+	return 0, true
+}
+
+// eval evaluates the expression of #if. known is false when it depends on
+// a macro which is unknown or cannot be evaluated.
+func (pp *preprocessor) eval(expr string) (int64, bool) {
+	e := &cppExpr{pp, tokenizeExpr(expr), 0, 0}
+	value, known := e.ternary()
+	if e.pos != len(e.tokens) {
+		return 0, false
+	}
+	return value, known
+}
+
+var re_expr_token = regexp.MustCompile(`\s*(\d\w*|\w+|<<|>>|<=|>=|==|!=|&&|\|\||[-+*/%!~<>&^|?:()])`)
+
+func tokenizeExpr(expr string) []string {
+	tokens := []string{}
+	for _, match := range re_expr_token.FindAllStringSubmatch(expr, -1) {
+		tokens = append(tokens, match[1])
+	}
+	return tokens
+}
+
+// cppExpr is a recursive descent parser of the expression of #if, where
+// every value carries whether it is known.
+type cppExpr struct {
+	pp     *preprocessor
+	tokens []string
+	pos    int
+	depth  int // Of macros expanded, against recursive macros
+}
+
+func (e *cppExpr) peek() string {
+	if e.pos < len(e.tokens) {
+		return e.tokens[e.pos]
+	}
+	return ""
+}
+
+func (e *cppExpr) next() string {
+	token := e.peek()
+	e.pos += 1
+	return token
+}
+
+func (e *cppExpr) ternary() (int64, bool) {
+	cond, cond_known := e.binary(0)
+	if e.peek() != "?" {
+		return cond, cond_known
+	}
+	e.next()
+	a, a_known := e.ternary()
+	if e.next() != ":" {
+		return 0, false
+	}
+	b, b_known := e.ternary()
+	if !cond_known {
+		return 0, false
+	}
+	if cond != 0 {
+		return a, a_known
+	}
+	return b, b_known
+}
+
+var cpp_operators = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", ">", "<=", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (e *cppExpr) binary(level int) (int64, bool) {
+	if level == len(cpp_operators) {
+		return e.unary()
+	}
+
+	a, a_known := e.binary(level + 1)
+	for {
+		op := e.peek()
+		found := false
+		for _, o := range cpp_operators[level] {
+			if op == o {
+				found = true
+			}
+		}
+		if !found {
+			return a, a_known
+		}
+		e.next()
+		b, b_known := e.binary(level + 1)
+
+		// Either side decides these alone
+		switch {
+		case op == "&&" && ((a_known && a == 0) || (b_known && b == 0)):
+			a, a_known = 0, true
+			continue
+		case op == "||" && ((a_known && a != 0) || (b_known && b != 0)):
+			a, a_known = 1, true
+			continue
+		}
+
+		if !a_known || !b_known {
+			a, a_known = 0, false
+			continue
+		}
+		a, a_known = binaryOp(op, a, b)
+	}
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func binaryOp(op string, a, b int64) (int64, bool) {
+	switch op {
+	case "||":
+		return boolValue(a != 0 || b != 0), true
+	case "&&":
+		return boolValue(a != 0 && b != 0), true
+	case "|":
+		return a | b, true
+	case "^":
+		return a ^ b, true
+	case "&":
+		return a & b, true
+	case "==":
+		return boolValue(a == b), true
+	case "!=":
+		return boolValue(a != b), true
+	case "<":
+		return boolValue(a < b), true
+	case ">":
+		return boolValue(a > b), true
+	case "<=":
+		return boolValue(a <= b), true
+	case ">=":
+		return boolValue(a >= b), true
+	case "<<":
+		return a << uint64(b), true
+	case ">>":
+		return a >> uint64(b), true
+	case "+":
+		return a + b, true
+	case "-":
+		return a - b, true
+	case "*":
+		return a * b, true
+	case "/", "%":
+		if b == 0 {
+			return 0, false
+		}
+		if op == "/" {
+			return a / b, true
+		}
+		return a % b, true
+	}
+	return 0, false
+}
+
+func (e *cppExpr) unary() (int64, bool) {
+	switch token := e.next(); token {
+	case "!":
+		value, known := e.unary()
+		return boolValue(value == 0), known
+	case "~":
+		value, known := e.unary()
+		return ^value, known
+	case "-":
+		value, known := e.unary()
+		return -value, known
+	case "+":
+		return e.unary()
+	case "(":
+		value, known := e.ternary()
+		if e.next() != ")" {
+			return 0, false
+		}
+		return value, known
+	case "defined":
+		name := e.next()
+		if name == "(" {
+			name = e.next()
+			if e.next() != ")" {
+				return 0, false
+			}
+		}
+		return e.pp.defined(name)
+	case "":
+		return 0, false
+	default:
+		if token[0] >= '0' && token[0] <= '9' {
+			value, err := strconv.ParseInt(strings.TrimRight(token, "uUlL"), 0, 64)
+			return value, err == nil
+		}
+		return e.ident(token)
+	}
+}
+
+// ident is the value of a macro in an expression.
+func (e *cppExpr) ident(name string) (int64, bool) {
+	m, ok := e.pp.macros[name]
+	if !ok || e.depth > 16 {
+		return 0, false
+	}
+	if !m.defined {
+		// An undefined identifier is 0 in #if
+		return 0, true
+	}
+	sub := &cppExpr{e.pp, tokenizeExpr(m.value), 0, e.depth + 1}
+	value, known := sub.ternary()
+	if sub.pos != len(sub.tokens) || len(sub.tokens) == 0 {
+		return 0, false
+	}
+	return value, known
+}
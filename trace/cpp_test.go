@@ -0,0 +1,36 @@
+package trace
+
+import (
+	"testing"
+)
+
+func TestPreprocessorEval(t *testing.T) {
+
+	pp := newPreprocessor([]string{"A", "B=2", "!C"})
+	pp.run("#define D (B * 3)")
+
+	cases := []struct {
+		expr  string
+		value int64
+		known bool
+	}{
+		{"0", 0, true},
+		{"A", 1, true},
+		{"defined(A) && !defined C", 1, true},
+		{"D == 6", 1, true},
+		{"B > 1 ? 10 : 20", 10, true},
+		{"C", 0, true},
+		{"UNKNOWN", 0, false},
+		{"UNKNOWN && 0", 0, true},
+		{"UNKNOWN || A", 1, true},
+		{"0x10 + 1UL", 17, true},
+		{"(1", 0, false},
+	}
+
+	for _, c := range cases {
+		if value, known := pp.eval(c.expr); value != c.value || known != c.known {
+			t.Errorf("%s is %d %v.", c.expr, value, known)
+		}
+	}
+
+}
@@ -0,0 +1,180 @@
+package trace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+// Parsers of Config.Parser, i.e. how the decls and the calls are found.
+const (
+	PARSERRAW   = "raw"   // Braces and regular expressions, without headers (default)
+	PARSERCLANG = "clang" // The AST of libclang
+)
+
+func isParser(parser string) bool {
+	return parser == PARSERRAW || parser == PARSERCLANG
+}
+
+func (cfg *Config) parser() string {
+	if cfg == nil || cfg.Parser == "" {
+		return PARSERRAW
+	}
+	return cfg.Parser
+}
+
+// clangArgs passes the defines to clang as the -D and -U options.
+func clangArgs(defines []string) []string {
+	args := []string{}
+	for _, define := range defines {
+		if strings.HasPrefix(define, "!") {
+			args = append(args, "-U"+define[1:])
+		} else {
+			args = append(args, "-D"+define)
+		}
+	}
+	return args
+}
+
+// clangHead is the text of a decl from its start up to the brace opening the
+// body, with the lines joined like the head of GetDeclsByRaw.
+func clangHead(src []byte, start, end uint32) string {
+	if int(end) > len(src) || start > end {
+		return ""
+	}
+	text := string(src[start:end])
+	if i := strings.Index(text, "{"); i >= 0 {
+		text = text[:i+1]
+	}
+	lines := []string{}
+	for _, ln := range strings.Split(text, "\n") {
+		if ln = strings.TrimSpace(ln); ln != "" {
+			lines = append(lines, ln)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// clangKind maps the functions and the classes of C++ to the kinds of Decl.
+func clangKind(kind clang.CursorKind) clang.CursorKind {
+	switch kind {
+	case clang.Cursor_CXXMethod, clang.Cursor_Constructor, clang.Cursor_Destructor,
+		clang.Cursor_ConversionFunction, clang.Cursor_FunctionTemplate:
+		return clang.Cursor_FunctionDecl
+	case clang.Cursor_ClassDecl:
+		return clang.Cursor_StructDecl
+	}
+	return kind
+}
+
+// clangName qualifies the name of cursor with its namespaces and classes
+// like "ns::Foo::bar", as GetDeclsByRaw does for C++.
+func clangName(cursor clang.Cursor) string {
+	name := cursor.Spelling()
+	for p := cursor.SemanticParent(); !p.IsNull(); p = p.SemanticParent() {
+		switch p.Kind() {
+		case clang.Cursor_Namespace, clang.Cursor_ClassDecl, clang.Cursor_StructDecl:
+			if p.Spelling() != "" {
+				name = p.Spelling() + "::" + name
+			}
+			continue
+		}
+		break
+	}
+	return name
+}
+
+type clangCall struct {
+	line uint32
+	name string
+}
+
+// clangLines groups the calls by line like readIdents, where the functions
+// called are both the idents and the calls of the line.
+func clangLines(decls Decls, calls []clangCall) []lineIdents {
+	sort.SliceStable(calls, func(i, j int) bool {
+		return calls[i].line < calls[j].line
+	})
+
+	lines := []lineIdents{}
+	for _, call := range calls {
+		n := len(lines)
+		if n == 0 || lines[n-1].line != call.line {
+			lines = append(lines, lineIdents{call.line, findDecl(decls, call.line), []string{}, []RefKind{}, []string{}, nil, nil, nil})
+			n += 1
+		}
+		ln := &lines[n-1]
+		ln.calls = append(ln.calls, call.name)
+		seen := false
+		for _, ident := range ln.idents {
+			seen = seen || ident == call.name
+		}
+		if !seen {
+			ln.idents = append(ln.idents, call.name)
+			ln.kinds = append(ln.kinds, REFCALL)
+		}
+	}
+	return lines
+}
+
+// parseByClang finds the function and struct definitions of path and the
+// calls in them from the AST. The line of a decl is the end of its extent
+// as in GetDeclsByRaw. Headers included are parsed but not taken.
+func parseByClang(path string, args []string) (Decls, []lineIdents, error) {
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	idx := clang.NewIndex(0, 0)
+	defer idx.Dispose()
+
+	tu := idx.ParseTranslationUnit(path, args, nil, 0)
+	if !tu.IsValid() {
+		return nil, nil, fmt.Errorf("clang cannot parse %s", path)
+	}
+	defer tu.Dispose()
+
+	decls := Decls{}
+	calls := []clangCall{}
+
+	tu.TranslationUnitCursor().Visit(func(cursor, parent clang.Cursor) clang.ChildVisitResult {
+
+		if !cursor.Location().IsFromMainFile() {
+			return clang.ChildVisit_Continue
+		}
+
+		switch kind := clangKind(cursor.Kind()); kind {
+		case clang.Cursor_FunctionDecl, clang.Cursor_StructDecl:
+			// Prototypes and forward declarations have no body to search
+			if !cursor.IsCursorDefinition() || cursor.Spelling() == "" {
+				break
+			}
+			_, start_line, _, start := cursor.Extent().RangeStart().ExpansionLocation()
+			_, end_line, _, end := cursor.Extent().RangeEnd().ExpansionLocation()
+			storage := Storage(0)
+			if kind == clang.Cursor_FunctionDecl {
+				storage = clangStorage(cursor)
+			}
+			decls = append(decls, Decl{end_line, kind, clangName(cursor), clangHead(src, start, end), storage, start_line})
+
+		case clang.Cursor_CallExpr:
+			name := cursor.Spelling()
+			if ref := cursor.Referenced(); !ref.IsNull() {
+				name = ref.Spelling()
+			}
+			if _, line, _, _ := cursor.Location().ExpansionLocation(); name != "" {
+				calls = append(calls, clangCall{line, name})
+			}
+		}
+
+		return clang.ChildVisit_Recurse
+	})
+
+	sort.Sort(decls)
+	return decls, clangLines(decls, calls), nil
+}
@@ -0,0 +1,89 @@
+package trace
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+func TestClangHead(t *testing.T) {
+
+	src := []byte("static int\nhandler(int a,\n        int b)\n{\n\treturn a;\n}\n")
+
+	if head := clangHead(src, 0, uint32(len(src))); head != "static int handler(int a, int b) {" {
+		t.Errorf("Head is %q.", head)
+	}
+
+}
+
+func TestClangLines(t *testing.T) {
+
+	decls := Decls{
+		Decl{6, clang.Cursor_FunctionDecl, "f", "void f(void) {", 0, 1},
+		Decl{12, clang.Cursor_FunctionDecl, "g", "void g(void) {", 0, 8},
+	}
+	calls := []clangCall{{10, "f"}, {3, "a"}, {3, "b"}, {3, "a"}}
+
+	expected := []lineIdents{
+		lineIdents{3, 0, []string{"a", "b"}, []RefKind{REFCALL, REFCALL}, []string{"a", "b", "a"}, nil, nil, nil},
+		lineIdents{10, 1, []string{"f"}, []RefKind{REFCALL}, []string{"f"}, nil, nil, nil},
+	}
+
+	if lines := clangLines(decls, calls); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Lines are %v.", lines)
+	}
+
+}
+
+func TestParseByClang(t *testing.T) {
+
+	path := filepath.Join("testdata", "tree", "src", "main.c")
+
+	decls, lines, backend, err := parse(path, PARSERCLANG, nil, nil)
+	if backend != PARSERCLANG {
+		t.Skipf("libclang is not available: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Decls{
+		Decl{7, clang.Cursor_FunctionDecl, "main", "int main(int argc, char **argv) {", 0, 1},
+		Decl{12, clang.Cursor_FunctionDecl, "loop_a", "void loop_a(int n) {", 0, 9},
+		Decl{17, clang.Cursor_FunctionDecl, "loop_b", "void loop_b(int n) {", 0, 14},
+		Decl{22, clang.Cursor_FunctionDecl, "dump", "static void dump(int n) {", STORAGESTATIC, 19},
+		Decl{27, clang.Cursor_FunctionDecl, "debug", "void debug(int n) {", 0, 24},
+	}
+	if !reflect.DeepEqual(decls, expected) {
+		t.Errorf("Decls are %v.", decls)
+	}
+
+	// The calls of the AST in their functions, where those of main are left
+	// out as struct packet is incomplete in the file
+	calls := map[uint32][]string{}
+	decl := map[uint32]int{}
+	for _, ln := range lines {
+		if len(ln.calls) > 0 && ln.line > 7 {
+			calls[ln.line] = ln.calls
+			decl[ln.line] = ln.decl
+		}
+	}
+	expected_calls := map[uint32][]string{11: {"loop_b"}, 16: {"loop_a"}, 21: {"loop_b"}, 26: {"dump"}}
+	expected_decl := map[uint32]int{11: 1, 16: 2, 21: 3, 26: 4}
+	if !reflect.DeepEqual(calls, expected_calls) || !reflect.DeepEqual(decl, expected_decl) {
+		t.Errorf("Calls are %v in %v.", calls, decl)
+	}
+
+	// The same trace as the raw parser
+	expected_trace := `-1- Entry point testdata/tree/src/main.c@L11 in loop_a function scope.
+ -2- loop_a testdata/tree/src/main.c@L11 calls loop_b defined in testdata/tree/src/main.c@L17.
+  -3- loop_b testdata/tree/src/main.c@L16 calls loop_a defined in testdata/tree/src/main.c@L12. ` + "↺" + ` already on path
+`
+	opts := Options{Entry: Entry{"src/main.c", 11}, MaxLevel: 6, Forward: true, Config: &Config{Parser: PARSERCLANG}}
+	if result := searchFixture(t, opts); result != expected_trace {
+		t.Errorf("Unexpected forward trace by clang:\n%s", result)
+	}
+
+}
@@ -0,0 +1,102 @@
+package trace
+
+import (
+	"os"
+	"strings"
+)
+
+func isNotFunc(ln string) bool {
+	return strings.ContainsAny(ln, "#;")
+}
+
+func reset(s *[]string) {
+	*s = []string{}
+}
+
+// getStructName returns the name of a variable of a struct type initialized
+// at file scope, or the struct itself for a definition like "struct name {".
+func getStructName(s string) string {
+	tokens := strings.Split(s, " ")
+	for i, token := range tokens {
+		if token == "struct" {
+			if i < len(tokens)-2 {
+				if strings.HasPrefix(tokens[i+2], "{") {
+					return tokens[i+1]
+				}
+				return tokens[i+2]
+			}
+		}
+	}
+	return ""
+}
+
+func getFuncName(s string) string {
+	func_decl := strings.Split(s, "(")
+	if len(func_decl) > 1 {
+		tokens := strings.Split(strings.TrimSpace(func_decl[0]), " ")
+		if len(tokens) > 0 {
+			func_name := tokens[len(tokens)-1]
+			if len(func_name) == 0 {
+				//panic("The function name is not taken successfully.")
+				return ""
+			}
+			if func_name[0] == '*' {
+				return func_name[1:]
+			} else {
+				return func_name
+			}
+		}
+	}
+	return ""
+}
+
+// GetDeclsByRaw parses the functions and structs defined in path without
+// libclang, by counting braces line by line. A *ParseError is returned with
+// the decls parsed anyway when the braces do not match. Only one branch of
+// each #if is parsed, which is evaluated with defines like "NAME=VALUE" if
+// possible.
+func GetDeclsByRaw(path string, defines ...string) (Decls, error) {
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var warning error
+
+	var decls Decls
+
+	pp := newPreprocessor(defines)
+	scope := &rawScope{cxx: isCxx(path)}
+
+	tz := newTokenizer(fd, scope.cxx)
+
+	real_ln := ""
+	code := true
+
+	for tz.scan() {
+
+		line := tz.line
+		real_ln = tz.code
+		tokens := tz.tokens
+
+		// Braces in directives and in the branches not parsed are not counted
+		if code = pp.line(real_ln); !code {
+			real_ln = ""
+			tokens = nil
+		}
+
+		decls = append(decls, scope.line(real_ln, tokens, code, line)...)
+
+		// Go on from the top level so that the rest of the file is parsed
+		if scope.global < 0 {
+			if warning == nil {
+				warning = &ParseError{path, line, "Scope must not be negative."}
+			}
+			scope.reset()
+		}
+
+	}
+	return decls, warning
+}
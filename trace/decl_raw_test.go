@@ -0,0 +1,403 @@
+package trace
+
+import (
+	"fmt"
+	"github.com/go-clang/bootstrap/clang"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGetDeclByRaw(t *testing.T) {
+
+	tmp := ".tmp"
+	source := `#include <stdio.h>
+
+namespace test {
+	int hoge(int i, int *j) { // test code
+		printf("{ } } } \" } } ");
+	}
+	
+	/* } text code } */
+	struct human *get_human() {
+		struct human *h = { "name",
+		age,height,weight /* test code */
+		/*test_code*/};
+		struct human *u = { name,age,height,weight };
+		return h;
+	}
+}
+
+static struct ccchar *baz ( char *i, struct *tree ) {
+	char a = '{';
+	char b = '{{';
+	char c = '}}}';
+	char d = '}}';
+
+	if ( "a" == '}' ) {
+		ccchar *x = strcat(a,b,c,d);
+		/*
+			XXX: { is not } but {
+			/* //
+		"test code" */
+		while() { /*
+		* test
+		*/ }
+		return *x;
+	} else {
+		return "x";
+	}
+}
+
+struct *st f(struct s* _s) {
+	if() { /* aaa
+		*/ if() { /* bbb
+			*/
+			if() { /* ccc
+			*/ if() {
+				if() { if () { } }
+				}
+			}
+		}
+	}
+}
+`
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		t.Errorf("Tmp file could not open.")
+	}
+	file.Write([]byte(source))
+
+	decls := Decls{
+		Decl{6, clang.Cursor_FunctionDecl, "hoge", "int hoge(int i, int *j) {", 0, 4},
+		Decl{15, clang.Cursor_FunctionDecl, "get_human", "struct human *get_human() {", 0, 9},
+		Decl{37, clang.Cursor_FunctionDecl, "baz", "static struct ccchar *baz ( char *i, struct *tree ) {", STORAGESTATIC, 18},
+		Decl{50, clang.Cursor_FunctionDecl, "f", "struct *st f(struct s* _s) {", 0, 39},
+	}
+
+	test_decls, err := GetDeclsByRaw(".tmp")
+	if err != nil {
+		t.Errorf("%s", err.Error())
+	}
+	if !reflect.DeepEqual(decls, test_decls) {
+		t.Errorf("Failed.")
+		fmt.Println("Assumed result.")
+		for i, decl := range decls {
+			fmt.Println(i, decl.Start, decl.Line, decl.Kind, decl.Name, decl.Head)
+		}
+		fmt.Println("\nActual result.")
+		for i, decl := range test_decls {
+			fmt.Println(i, decl.Start, decl.Line, decl.Kind, decl.Name, decl.Head)
+		}
+	}
+
+	os.Remove(tmp)
+
+}
+
+func TestGetDeclByRawNegativeScope(t *testing.T) {
+
+	tmp := ".tmp_negative"
+	source := `int foo() {
+	return 0;
+}
+}
+
+int bar() {
+	return 1;
+}
+`
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		t.Errorf("Tmp file could not open.")
+	}
+	file.Write([]byte(source))
+	defer os.Remove(tmp)
+
+	decls, err := GetDeclsByRaw(tmp)
+
+	warning, ok := err.(*ParseError)
+	if !ok || warning.Line != 4 {
+		t.Errorf("Expected a warning at line 4, got %v.", err)
+	}
+
+	// The file is parsed on after the extra brace
+	if len(decls) != 2 || decls[1].Name != "bar" {
+		t.Errorf("Failed. %v", decls)
+	}
+
+}
+
+func TestGetDeclByRawPreprocessor(t *testing.T) {
+
+	tmp := ".tmp_cpp"
+	source := `#define LOCK(l) do { \
+	take(l); \
+} while (0)
+
+#if 0
+int dead(void) {
+#endif
+
+#ifdef USE_NEW
+int handler(int a, int b)
+#else
+int handler(int a)
+#endif
+{
+	LOCK(a);
+	return a;
+}
+
+#if LEVEL > 1
+int level2(void) {
+	return 2;
+}
+#else
+int level1(void) {
+	return 1;
+}
+#endif
+`
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		t.Errorf("Tmp file could not open.")
+	}
+	file.Write([]byte(source))
+	defer os.Remove(tmp)
+
+	// The first branch is taken when the condition is unknown
+	decls := Decls{
+		Decl{17, clang.Cursor_FunctionDecl, "handler", "int handler(int a, int b) {", 0, 10},
+		Decl{22, clang.Cursor_FunctionDecl, "level2", "int level2(void) {", 0, 20},
+	}
+
+	test_decls, err := GetDeclsByRaw(tmp)
+	if err != nil || !reflect.DeepEqual(decls, test_decls) {
+		t.Errorf("Failed without defines. %v %v", test_decls, err)
+	}
+
+	decls = Decls{
+		Decl{17, clang.Cursor_FunctionDecl, "handler", "int handler(int a) {", 0, 12},
+		Decl{26, clang.Cursor_FunctionDecl, "level1", "int level1(void) {", 0, 24},
+	}
+
+	test_decls, err = GetDeclsByRaw(tmp, "!USE_NEW", "LEVEL=1")
+	if err != nil || !reflect.DeepEqual(decls, test_decls) {
+		t.Errorf("Failed with defines. %v %v", test_decls, err)
+	}
+
+}
+
+func TestExclude(t *testing.T) {
+
+	a := "aaa /* bbb */ ccc"
+	if lexCode(a) != "aaa   ccc" {
+		t.Errorf("%s failed.", a)
+	}
+
+	a = "aaa \" bbb \" ccc"
+	if lexCode(a) != "aaa  ccc" {
+		t.Errorf("%s failed.", a)
+	}
+
+	a = "aaa \" bbb \" ccc \" ddd \" eee"
+	if lexCode(a) != "aaa  ccc  eee" {
+		t.Errorf("%s failed.", a)
+	}
+
+	a = "aaa \" /* bbb \" ccc \" ddd */ \" eee \" // xxx \" yyy // zzz "
+	if lexCode(a) != "aaa  ccc  eee  yyy " {
+		t.Errorf("%s failed.", a)
+	}
+
+	a = "000 ' 111 ' aaa \" /* bbb \" ccc \" ddd */ \" eee \" // xxx \" yyy // zzz "
+	if lexCode(a) != "000  aaa  ccc  eee  yyy " {
+		t.Errorf("%s failed.", a)
+	}
+
+	a = "000 ' 111 // ' aaa \" /* bbb \" ccc \" ddd */ \" eee \" // xxx \" yyy // zzz "
+	if lexCode(a) != "000  aaa  ccc  eee  yyy " {
+		t.Errorf("%s failed.", a)
+	}
+
+	a = "000 ' 111 // ' \\\" aaa \" /* bbb \" ccc \" ddd */ \" eee \" // xxx \" yyy '---' \\\" // zzz "
+	if lexCode(a) != "000   aaa  ccc  eee  yyy   " {
+		t.Errorf("%s failed.", a)
+	}
+
+	a = "000 /*' 111 // ' aaa \" /* bbb \" ccc \" ddd */ \" eee \" // xxx \" yyy //*/ zzz "
+	if lexCode(a) != "000    " {
+		t.Errorf("%s failed.", a)
+	}
+
+	a = "0\"1\"2\"3\"4\"5\"6\"7\"8\na'b'c'd'e'f'g'h'i\rA/*B*/C/*D*/E/*F*/G/*H*/I\to\"p\"q'r's/*t*/u//v"
+	if lexCode(a) != "02468\nacegi\rA C E G I\toqs u" {
+		t.Errorf("%s failed.", a)
+	}
+
+	// A comment is a space between the tokens
+	a = "int/**/x = a/**/*/**/b;"
+	if lexCode(a) != "int x = a * b;" {
+		t.Errorf("%s failed.", a)
+	}
+
+}
+
+func TestGetDeclsByRawCxx(t *testing.T) {
+
+	tmp := ".tmp.cpp"
+	source := `namespace geo {
+
+template <typename T>
+class Shape : public Base<T> {
+public:
+	Shape(int n) : sides(n) {}
+	~Shape() {}
+	bool operator==(const Shape &o) const { return sides == o.sides; }
+private:
+	int sides;
+};
+
+int Shape::area() const
+{
+	return sides;
+}
+
+}
+
+namespace geo
+{
+int perimeter(int n)
+{
+	return n;
+}
+}
+`
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		t.Errorf("Tmp file could not open.")
+	}
+	file.Write([]byte(source))
+	defer os.Remove(tmp)
+
+	decls := Decls{
+		Decl{6, clang.Cursor_FunctionDecl, "geo::Shape::Shape", "Shape(int n) : sides(n) {}", 0, 6},
+		Decl{7, clang.Cursor_FunctionDecl, "geo::Shape::~Shape", "~Shape() {}", 0, 7},
+		Decl{8, clang.Cursor_FunctionDecl, "geo::Shape::operator==", "bool operator==(const Shape &o) const { return sides == o.sides; }", 0, 8},
+		Decl{11, clang.Cursor_StructDecl, "geo::Shape", "template <typename T> class Shape : public Base<T> {", 0, 3},
+		Decl{16, clang.Cursor_FunctionDecl, "geo::Shape::area", "int Shape::area() const {", 0, 13},
+		Decl{25, clang.Cursor_FunctionDecl, "geo::perimeter", "int perimeter(int n) {", 0, 22},
+	}
+
+	test_decls, err := GetDeclsByRaw(tmp)
+	if err != nil || !reflect.DeepEqual(decls, test_decls) {
+		t.Errorf("Failed with C++. %v %v", test_decls, err)
+	}
+
+}
+
+func TestGetDeclsByRawLexer(t *testing.T) {
+
+	tmp := ".tmp_lexer.cpp"
+	source := `const char *usage = R"usage(
+int fake() {
+	} /* "
+)usage";
+
+int limit(int n) {
+	return n < 1'000'000 ? n : 1'000'000;
+}
+
+auto twice = [](int x) {
+	return x * 2;
+};
+
+static int token = reg([](int id) {
+	return limit(id);
+});
+
+template <typename T>
+std::function<void(T)> make(T t) {
+	return [t](T u) { use(t, u); };
+}
+`
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		t.Errorf("Tmp file could not open.")
+	}
+	file.Write([]byte(source))
+	defer os.Remove(tmp)
+
+	decls := Decls{
+		Decl{8, clang.Cursor_FunctionDecl, "limit", "int limit(int n) {", 0, 6},
+		Decl{12, clang.Cursor_FunctionDecl, "twice", "auto twice = [](int x) {", 0, 10},
+		Decl{21, clang.Cursor_FunctionDecl, "make", "template <typename T> std::function<void(T)> make(T t) {", 0, 18},
+	}
+
+	test_decls, err := GetDeclsByRaw(tmp)
+	if err != nil || !reflect.DeepEqual(decls, test_decls) {
+		t.Errorf("Failed with the lexer. %v %v", test_decls, err)
+	}
+
+	// The call in the lambda passed to reg is in no function, not in make
+	for _, ln := range readIdents(tmp, test_decls, nil) {
+		if ln.line == 15 && ln.decl != -1 {
+			t.Errorf("Line 15 is in %v.", test_decls[ln.decl])
+		}
+		if ln.line == 11 && ln.decl != 1 {
+			t.Errorf("Line 11 is not in twice but %d.", ln.decl)
+		}
+		for _, ident := range append(ln.idents, ln.calls...) {
+			if isKeyword(ident) {
+				t.Errorf("Keyword %s is on line %d.", ident, ln.line)
+			}
+		}
+	}
+
+}
+
+func TestGetDeclsByRawOuterNames(t *testing.T) {
+
+	tmp := ".tmp_outer.c"
+	source := `extern "C" {
+static int external_count(void) {
+	return namespace_size;
+}
+}
+
+int extern_ok(int namespace_id) { return namespace_id; }
+
+extern "C"
+{
+int external_sum(void)
+{
+	return external_count();
+}
+}
+`
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		t.Errorf("Tmp file could not open.")
+	}
+	file.Write([]byte(source))
+	defer os.Remove(tmp)
+
+	decls := Decls{
+		Decl{4, clang.Cursor_FunctionDecl, "external_count", "static int external_count(void) {", STORAGESTATIC, 2},
+		Decl{7, clang.Cursor_FunctionDecl, "extern_ok", "int extern_ok(int namespace_id) { return namespace_id; }", 0, 7},
+		Decl{14, clang.Cursor_FunctionDecl, "external_sum", "int external_sum(void) {", 0, 11},
+	}
+
+	test_decls, err := GetDeclsByRaw(tmp)
+	if err != nil || !reflect.DeepEqual(decls, test_decls) {
+		t.Errorf("Failed with extern in names. %v %v", test_decls, err)
+	}
+
+}
@@ -0,0 +1,38 @@
+package trace
+
+import (
+	"fmt"
+)
+
+// EntryError tells that the entry point or the target of a search does not
+// point at any function or struct in the tree.
+type EntryError struct {
+	Entry  string
+	Reason string
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("%s %s.", e.Entry, e.Reason)
+}
+
+// OptionError is an invalid value in Options.
+type OptionError struct {
+	Option string
+	Value  string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("Invalid %s %q.", e.Option, e.Value)
+}
+
+// ParseError is a problem in one file. It is only a warning, i.e. the decls
+// parsed from the file are still used and the search goes on.
+type ParseError struct {
+	Path string
+	Line uint32
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"fmt"
+	"strings"
+)
+
+// readCallees lists the functions called in the body of t.callee, which is
+// the opposite direction of readNthFunc.
+func (t *Trace) readCallees() {
+
+	decl := findDecl(t.index.decls[t.callee.File], t.callee.Line)
+	if decl < 0 {
+		return
+	}
+
+	h := fmt.Sprintf("%s-%d-", strings.Repeat(" ", t.level-1), t.level)
+
+	// Like the backtrace, every call site is listed but a callee is expanded
+	// only at its first call site in the body
+	expanded := make(map[string]bool)
+
+	for _, ln := range t.index.lines[t.callee.File] {
+
+		if ln.decl != decl {
+			continue
+		}
+
+		if t.pool.Cancelled() {
+			t.pool.Truncate(t)
+			return
+		}
+
+		for _, name := range ln.calls {
+
+			if name == t.callee.Fun {
+				continue
+			}
+
+			for _, def := range t.index.Definitions(name, t.callee.File) {
+
+				callee_decl := t.index.decls[def.file][def.decl]
+
+				result := fmt.Sprintf("%s %s %s@L%d calls \x1b[34m%s\x1b[0m defined in %s@L%d.\n",
+					h, t.callee.Fun, t.callee.File, ln.line, name, def.file, callee_decl.Line)
+
+				callee := Callee{name, def.file, callee_decl.Line, callee_decl.Head}
+
+				site := Entry{t.callee.File, ln.line}
+
+				if t.onPath(callee) {
+					t.addNode(t.newChild(site, callee, markResult(result, CYCLEMARK)))
+					continue
+				}
+
+				trace := t.newChild(site, callee, result)
+				t.addNode(trace)
+
+				if !expanded[callee.key()] {
+					expanded[callee.key()] = true
+					t.expand(trace)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package trace
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+// Occurrence is a line where an identifier appears inside some scope.
+type Occurrence struct {
+	file  string
+	line  uint32
+	decl  int     // Index of the enclosing decl in the file's Decls, -1 if none
+	kinds RefKind // How the identifier is referred to on the line
+}
+
+// Index is built once per run so that each trace level is a map lookup
+// instead of another walk over the whole tree. It is never modified after
+// BuildIndex returns, so the workers read it without locking.
+type Index struct {
+	files    []string
+	decls    map[string]Decls
+	lines    map[string][]lineIdents
+	idents   map[string][]Occurrence
+	funcs    map[string][]Occurrence // Definitions by baseName, where line is the decl line
+	binds    map[string][]string     // Fields and pointers each name is assigned to
+	indirect map[string][]Occurrence // Calls through the fields and pointers
+	incs     map[string][]string     // Files included by each file
+	cxx      map[string]bool
+	names    map[string][]string // Files where each identifier appears
+	pointers map[string]bool     // Function pointers declared in any file
+
+	defines  []string
+	warnings []error
+}
+
+func newIndex(defines []string) *Index {
+	return &Index{[]string{}, make(map[string]Decls), make(map[string][]lineIdents),
+		make(map[string][]Occurrence), make(map[string][]Occurrence),
+		make(map[string][]string), make(map[string][]Occurrence), make(map[string][]string),
+		make(map[string]bool), make(map[string][]string), make(map[string]bool), defines, nil}
+}
+
+// BuildIndex loads the persisted index of dir, re-parses only the files
+// which changed since the last run and saves it back.
+func BuildIndex(dir string, cfg *Config) *Index {
+	idx := newIndex(cfg.defines())
+
+	store := LoadIndexStore(dir, cfg)
+	store.refresh(func(path string, rec *FileRecord) {
+		idx.add(path, rec.decls(), rec.lines(), rec.Includes, rec.Cxx)
+		for _, name := range rec.Names {
+			idx.names[name] = append(idx.names[name], path)
+		}
+		idx.warnings = append(idx.warnings, rec.warnings(path)...)
+	})
+	idx.warnings = append(idx.warnings, store.Warnings()...)
+
+	// The index is still usable for this run
+	if err := store.save(); err != nil {
+		idx.warnings = append(idx.warnings, err)
+	}
+
+	return idx
+}
+
+// Warnings are the problems which did not stop indexing, e.g. a file whose
+// braces do not match.
+func (idx *Index) Warnings() []error {
+	return idx.warnings
+}
+
+// isHeader tells whether a function found in path is only a declaration or
+// a definition included by sources, whose scope is not a caller.
+func isHeader(path string) bool {
+	switch filepath.Ext(path) {
+	case ".h", ".hh", ".hpp", ".hxx":
+		return true
+	}
+	return false
+}
+
+func (idx *Index) add(path string, decls Decls, lines []lineIdents, includes []string, cxx bool) {
+	idx.files = append(idx.files, path)
+	idx.decls[path] = decls
+	idx.lines[path] = lines
+	idx.incs[path] = includes
+	idx.cxx[path] = cxx
+
+	for i, decl := range decls {
+		if decl.Kind == clang.Cursor_FunctionDecl {
+			name := baseName(decl.Name)
+			idx.funcs[name] = append(idx.funcs[name], Occurrence{path, decl.Line, i, REFDECL})
+		}
+	}
+
+	for _, occ := range lines {
+		for i, ident := range occ.idents {
+			idx.idents[ident] = append(idx.idents[ident], Occurrence{path, occ.line, occ.decl, occ.kinds[i]})
+		}
+		for _, via := range occ.indirect {
+			idx.indirect[via] = append(idx.indirect[via], Occurrence{path, occ.line, occ.decl, REFCALL})
+		}
+		for _, bind := range occ.binds {
+			idx.binds[bind.fun] = append(idx.binds[bind.fun], bind.via)
+		}
+		for _, pointer := range occ.pointers {
+			idx.pointers[pointer] = true
+		}
+	}
+}
+
+// Functions returns the definitions of the functions named query, which
+// may be qualified like "Foo::bar" or not like "bar".
+func (idx *Index) Functions(query string) []Occurrence {
+	defs := []Occurrence{}
+	for _, def := range idx.funcs[baseName(query)] {
+		if matchName(idx.decls[def.file][def.decl].Name, query) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// label is the name of decl in path to show, with the parameters when the
+// name is overloaded in C++.
+func (idx *Index) label(path string, decl Decl) string {
+	if decl.Kind != clang.Cursor_FunctionDecl || !idx.cxx[path] {
+		return decl.Name
+	}
+	n := 0
+	for _, def := range idx.funcs[baseName(decl.Name)] {
+		if other := idx.decls[def.file][def.decl]; idx.cxx[def.file] && other.Name == decl.Name &&
+			signature(other.Head, other.Name) != signature(decl.Head, decl.Name) {
+			n += 1
+		}
+	}
+	if n == 0 {
+		return decl.Name
+	}
+	return decl.Name + signature(decl.Head, decl.Name)
+}
+
+// Lookup returns the occurrences of ident in walk order and line order.
+func (idx *Index) Lookup(ident string) []Occurrence {
+	return idx.idents[ident]
+}
+
+// Definitions returns where a function named name is defined. A definition
+// in the file of the caller is preferred over the ones in other files, and
+// the static functions of other translation units are never called.
+func (idx *Index) Definitions(name, caller_file string) []Occurrence {
+	defs := []Occurrence{}
+	for _, def := range idx.funcs[name] {
+		if def.file == caller_file {
+			return []Occurrence{def}
+		}
+		if idx.visible(def, caller_file) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+type lineIdents struct {
+	line     uint32
+	decl     int
+	idents   []string
+	kinds    []RefKind // Of each of idents
+	calls    []string  // Identifiers followed by "("
+	indirect []string  // Calls through fields and pointers, see findIndirect
+	binds    []binding // Also found outside the bodies, e.g. in initializers
+	pointers []string  // Function pointers declared, also outside the bodies
+}
+
+// findDecl returns the decl whose head or body holds line, or -1 when line
+// is at file scope, e.g. a global or a blank line between two functions.
+func findDecl(decls Decls, line uint32) int {
+	for i, decl := range decls {
+		if decl.Start <= line && line <= decl.Line {
+			return i
+		}
+	}
+	return -1
+}
+
+// readIdents collects the distinct identifiers of every line that is inside
+// a function or struct body, following the same scoping as GetDeclsByRaw.
+func readIdents(path string, decls Decls, defines []string) []lineIdents {
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer fd.Close()
+
+	scope := &rawScope{cxx: isCxx(path)}
+
+	pp := newPreprocessor(defines)
+
+	tz := newTokenizer(fd, scope.cxx)
+
+	real_ln := ""
+
+	result := []lineIdents{}
+
+	for tz.scan() {
+		lines := tz.line
+		real_ln = tz.code
+		tokens := tz.tokens
+
+		code := pp.line(real_ln)
+		if !code {
+			real_ln = ""
+			tokens = nil
+		}
+
+		// The body of a function may end on the line, e.g. "int f() { return g(); }"
+		ends := false
+		for _, decl := range scope.line(real_ln, tokens, code, lines) {
+			ends = ends || decl.Kind == clang.Cursor_FunctionDecl
+		}
+		if scope.global < 0 {
+			scope.reset()
+		}
+
+		binds := findBinds(real_ln)
+		pointers := findPointers(real_ln)
+
+		if scope.inBody() || ends {
+			seen := make(map[string]bool)
+			idents := []string{}
+			calls := []string{}
+			for i, tk := range tokens {
+				if tk.kind != TOKENIDENT || isKeyword(tk.text) {
+					continue
+				}
+				if !seen[tk.text] {
+					seen[tk.text] = true
+					idents = append(idents, tk.text)
+				}
+				if i+1 < len(tokens) && punct(tokens[i+1], "(") {
+					calls = append(calls, tk.text)
+				}
+			}
+			// The lines of a lambda passed at file scope are in no function
+			decl := findDecl(decls, lines)
+			if scope.anon {
+				decl = -1
+			}
+			if len(idents) > 0 {
+				result = append(result, lineIdents{lines, decl, idents, refKinds(tokens, idents), calls, findIndirect(real_ln), binds, pointers})
+				binds, pointers = nil, nil
+			}
+		}
+
+		if len(binds) > 0 || len(pointers) > 0 {
+			result = append(result, lineIdents{lines, findDecl(decls, lines), []string{}, []RefKind{}, []string{}, []string{}, binds, pointers})
+		}
+	}
+
+	addPointerCalls(result)
+	return result
+}
@@ -0,0 +1,417 @@
+package trace
+
+import (
+	"crypto/md5"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+const (
+	INDEXDIR     = "index"
+	INDEXVERSION = 22
+)
+
+// Fields are exported only for encoding/gob.
+type DeclRecord struct {
+	Line    uint32
+	Kind    uint32
+	Name    string
+	Head    string
+	Storage uint8
+	Start   uint32
+}
+
+type LineRecord struct {
+	Line     uint32
+	Decl     int
+	Idents   []string
+	Kinds    []uint8
+	Calls    []string
+	Indirect []string
+	Binds    []BindRecord
+	Pointers []string
+}
+
+type BindRecord struct {
+	Via string
+	Fun string
+}
+
+// FileRecord is the parsed result of one file together with the stat and
+// content hash it was parsed from.
+type FileRecord struct {
+	ModTime  int64
+	Size     int64
+	Hash     string
+	Decls    []DeclRecord
+	Lines    []LineRecord
+	Warnings []ParseError // Path is left empty and set when loaded
+	Parser   string       // The parser which actually parsed the file
+	Includes []string     // As written in #include
+	Cxx      bool
+	Names    []string // Every identifier of the file, also at file scope
+}
+
+// IndexStore is the on-disk index of one root directory. Files are keyed by
+// their path relative to the root.
+type IndexStore struct {
+	Version int
+	Root    string
+	Parser  string // Every file is parsed again when Parser, Defines or CompDB differ
+	Defines []string
+	CompDB  string // Hash of the compilation database, "" without one
+	Files   map[string]*FileRecord
+
+	path     string
+	config   *Config
+	compdb   string // Path of the compilation database
+	warnings []error
+}
+
+type IndexStatus struct {
+	Fresh   int
+	Stale   int
+	Added   int
+	Removed int
+}
+
+func getIndexPath(dir string) string {
+	abs_dir, err := filepath.Abs(dir)
+	if err != nil {
+		abs_dir = dir
+	}
+	hashed := fmt.Sprintf("%x", md5.Sum([]byte(abs_dir)))
+	return filepath.Join(GetHomeEnv(), BTHOME, INDEXDIR, hashed)
+}
+
+// LoadIndexStore returns an empty store when there is no usable index yet.
+// The files to index are chosen by cfg, which may be nil.
+func LoadIndexStore(dir string, cfg *Config) *IndexStore {
+	path := getIndexPath(dir)
+	compdb := ""
+	if cfg.parser() == PARSERCLANG {
+		compdb = cfg.compdb(dir)
+	}
+	store := &IndexStore{INDEXVERSION, dir, cfg.parser(), cfg.defines(), "", make(map[string]*FileRecord), path, cfg, compdb, nil}
+	if compdb != "" {
+		store.CompDB = hashFile(compdb)
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return store
+	}
+	defer fd.Close()
+
+	saved := IndexStore{}
+	if err := gob.NewDecoder(fd).Decode(&saved); err != nil || saved.Version != INDEXVERSION {
+		return store
+	}
+	if saved.Parser != store.Parser || saved.CompDB != store.CompDB ||
+		strings.Join(saved.Defines, " ") != strings.Join(store.Defines, " ") {
+		return store
+	}
+
+	if saved.Files != nil {
+		store.Files = saved.Files
+	}
+	return store
+}
+
+func (s *IndexStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	// Write aside and rename so that a concurrent run never reads half an
+	// index, where each run has its own file aside
+	fd, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := fd.Name()
+
+	if err := gob.NewEncoder(fd).Encode(s); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func hashFile(path string) string {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", md5.Sum(body))
+}
+
+// isFresh tells whether rec still describes the file. The content hash is
+// only computed when mtime or size differ, and a matching hash refreshes
+// the stat of rec.
+func (rec *FileRecord) isFresh(path string, info os.FileInfo) bool {
+	if rec.ModTime == info.ModTime().UnixNano() && rec.Size == info.Size() {
+		return true
+	}
+	if hash := hashFile(path); hash != "" && hash == rec.Hash {
+		rec.ModTime = info.ModTime().UnixNano()
+		rec.Size = info.Size()
+		return true
+	}
+	return false
+}
+
+func parseFile(path string, info os.FileInfo, parser string, defines []string, db compDB) *FileRecord {
+	decls, lines, backend, err := parse(path, parser, defines, db)
+
+	rec := &FileRecord{info.ModTime().UnixNano(), info.Size(), hashFile(path), nil, nil, nil, backend, readIncludes(path), isCxx(path), readNames(path)}
+	if err != nil {
+		warning, ok := err.(*ParseError)
+		if !ok {
+			warning = &ParseError{path, 0, err.Error()}
+		}
+		rec.Warnings = append(rec.Warnings, ParseError{"", warning.Line, warning.Msg})
+	}
+	for _, decl := range decls {
+		rec.Decls = append(rec.Decls, DeclRecord{decl.Line, uint32(decl.Kind), decl.Name, decl.Head, uint8(decl.Storage), decl.Start})
+	}
+	for _, ln := range lines {
+		binds := []BindRecord{}
+		for _, bind := range ln.binds {
+			binds = append(binds, BindRecord{bind.via, bind.fun})
+		}
+		kinds := []uint8{}
+		for _, kind := range ln.kinds {
+			kinds = append(kinds, uint8(kind))
+		}
+		rec.Lines = append(rec.Lines, LineRecord{ln.line, ln.decl, ln.idents, kinds, ln.calls, ln.indirect, binds, ln.pointers})
+	}
+	return rec
+}
+
+func (rec *FileRecord) decls() Decls {
+	decls := Decls{}
+	for _, d := range rec.Decls {
+		decls = append(decls, Decl{d.Line, clang.CursorKind(d.Kind), d.Name, d.Head, Storage(d.Storage), d.Start})
+	}
+	return decls
+}
+
+func (rec *FileRecord) warnings(path string) []error {
+	warnings := []error{}
+	for _, w := range rec.Warnings {
+		warnings = append(warnings, &ParseError{path, w.Line, w.Msg})
+	}
+	return warnings
+}
+
+func (rec *FileRecord) lines() []lineIdents {
+	lines := []lineIdents{}
+	for _, l := range rec.Lines {
+		binds := []binding{}
+		for _, b := range l.Binds {
+			binds = append(binds, binding{b.Via, b.Fun})
+		}
+		kinds := []RefKind{}
+		for _, kind := range l.Kinds {
+			kinds = append(kinds, RefKind(kind))
+		}
+		lines = append(lines, lineIdents{l.Line, l.Decl, l.Idents, kinds, l.Calls, l.Indirect, binds, l.Pointers})
+	}
+	return lines
+}
+
+// walk visits the source files under every root. A file under two roots is
+// visited once. Inside a git repository the files are listed by git unless
+// the config says otherwise, and the whole root is walked when git fails.
+func (s *IndexStore) walk(fn func(path, rel string, info os.FileInfo)) {
+	visited := make(map[string]bool)
+	s.warnings = nil
+
+	visit := func(path string, info os.FileInfo) {
+		if !s.config.isSourceFile(s.Root, path) {
+			return
+		}
+		rel, err := filepath.Rel(s.Root, path)
+		if err != nil {
+			rel = path
+		}
+		if visited[rel] {
+			return
+		}
+		visited[rel] = true
+		fn(path, rel, info)
+	}
+
+	for _, root := range s.config.roots(s.Root) {
+
+		files, err := s.config.listFiles(root)
+		if err != nil {
+			s.warnings = append(s.warnings, err)
+		}
+
+		if files != nil {
+			for _, file := range files {
+				path := filepath.Join(root, file)
+				info, err := os.Lstat(path)
+				if err != nil || !info.Mode().IsRegular() || s.inExcludedDir(root, path) {
+					continue
+				}
+				visit(path, info)
+			}
+			continue
+		}
+
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if path != root && s.config.isExcluded(s.Root, path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			visit(path, info)
+			return nil
+		})
+	}
+}
+
+// inExcludedDir tells whether a directory between root and path is excluded,
+// as filepath.Walk would have skipped it.
+func (s *IndexStore) inExcludedDir(root, path string) bool {
+	for dir := filepath.Dir(path); dir != root && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if s.config.isExcluded(s.Root, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh re-parses new and changed files, forgets removed ones and hands
+// every record to fn in walk order.
+func (s *IndexStore) refresh(fn func(path string, rec *FileRecord)) IndexStatus {
+	status := IndexStatus{}
+	seen := make(map[string]bool)
+
+	// Without a usable database clang parses every file without flags
+	var db compDB
+	var db_err error
+	if s.compdb != "" {
+		db, db_err = loadCompDB(s.compdb)
+	}
+
+	s.walk(func(path, rel string, info os.FileInfo) {
+		seen[rel] = true
+
+		rec, ok := s.Files[rel]
+		switch {
+		case !ok:
+			status.Added += 1
+			rec = parseFile(path, info, s.Parser, s.Defines, db)
+		case !rec.isFresh(path, info):
+			status.Stale += 1
+			rec = parseFile(path, info, s.Parser, s.Defines, db)
+		default:
+			status.Fresh += 1
+		}
+		s.Files[rel] = rec
+
+		if fn != nil {
+			fn(path, rec)
+		}
+	})
+
+	for rel := range s.Files {
+		if !seen[rel] {
+			status.Removed += 1
+			delete(s.Files, rel)
+		}
+	}
+
+	if db_err != nil {
+		s.warnings = append(s.warnings, db_err)
+	}
+
+	return status
+}
+
+// Status counts fresh and stale files without parsing or saving anything.
+func (s *IndexStore) Status() IndexStatus {
+	status := IndexStatus{}
+	seen := make(map[string]bool)
+
+	s.walk(func(path, rel string, info os.FileInfo) {
+		seen[rel] = true
+
+		if rec, ok := s.Files[rel]; !ok {
+			status.Added += 1
+		} else if rec.isFresh(path, info) {
+			status.Fresh += 1
+		} else {
+			status.Stale += 1
+		}
+	})
+
+	for rel := range s.Files {
+		if !seen[rel] {
+			status.Removed += 1
+		}
+	}
+
+	return status
+}
+
+// Update re-parses new and changed files and saves the store.
+func (s *IndexStore) Update() (IndexStatus, error) {
+	status := s.refresh(nil)
+	return status, s.save()
+}
+
+// Path is where the store is saved.
+func (s *IndexStore) Path() string {
+	return s.path
+}
+
+// Warnings are the problems of the last walk, e.g. git failed to list files.
+func (s *IndexStore) Warnings() []error {
+	return s.warnings
+}
+
+// Parsers maps each file of the store, relative to the root, to the parser
+// which parsed it.
+func (s *IndexStore) Parsers() map[string]string {
+	parsers := make(map[string]string)
+	for rel, rec := range s.Files {
+		parsers[rel] = rec.Parser
+	}
+	return parsers
+}
+
+// CompDBPath is the compilation database used by clang, or "" when there
+// is none.
+func (s *IndexStore) CompDBPath() string {
+	return s.compdb
+}
+
+// Len is the number of files in the store.
+func (s *IndexStore) Len() int {
+	return len(s.Files)
+}
@@ -0,0 +1,73 @@
+package trace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndexStoreRefresh(t *testing.T) {
+
+	home, _ := ioutil.TempDir("", "rsb-home")
+	root, _ := ioutil.TempDir("", "rsb-root")
+	defer os.RemoveAll(home)
+	defer os.RemoveAll(root)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	a := filepath.Join(root, "a.c")
+	b := filepath.Join(root, "b.c")
+	ioutil.WriteFile(a, []byte("int a(void) {\n\treturn b();\n}\n"), 0644)
+	ioutil.WriteFile(b, []byte("int b(void) {\n\treturn 0;\n}\n"), 0644)
+
+	idx := BuildIndex(root, nil)
+
+	// The opening line of b itself is inside its scope as well
+	occs := idx.Lookup("b")
+	if len(occs) != 2 || occs[0].file != a || occs[0].line != 2 || idx.decls[a][occs[0].decl].Name != "a" {
+		t.Errorf("Unexpected occurrences of b: %v", occs)
+	}
+
+	store := LoadIndexStore(root, nil)
+	if s := store.Status(); s.Fresh != 2 || s.Stale != 0 || s.Added != 0 {
+		t.Errorf("Index should be fresh after build: %+v", s)
+	}
+
+	// Same content with a new mtime is still fresh thanks to the hash
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(b, later, later)
+	ioutil.WriteFile(a, []byte("int a(void) {\n\treturn 1;\n}\n"), 0644)
+	os.Chtimes(a, later, later)
+	os.Remove(b)
+	ioutil.WriteFile(filepath.Join(root, "c.h"), []byte(""), 0644)
+
+	if s := store.Status(); s.Fresh != 0 || s.Stale != 1 || s.Added != 1 || s.Removed != 1 {
+		t.Errorf("Unexpected status after changes: %+v", s)
+	}
+
+	idx = BuildIndex(root, nil)
+	if len(idx.Lookup("b")) != 0 || len(idx.files) != 2 {
+		t.Errorf("Stale occurrences of b are left in the index.")
+	}
+
+	// Concurrent runs write aside to their own files
+	done := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			done <- LoadIndexStore(root, nil).save()
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("Save failed: %v", err)
+		}
+	}
+	if files, _ := ioutil.ReadDir(filepath.Dir(store.path)); len(files) != 1 {
+		t.Errorf("Files are left aside the index: %d", len(files))
+	}
+	if s := LoadIndexStore(root, nil).Status(); s.Fresh != 2 {
+		t.Errorf("Index is broken by concurrent saves: %+v", s)
+	}
+}
@@ -0,0 +1,172 @@
+package trace
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// binding is a function assigned to a struct field or a function pointer,
+// e.g. ".read = my_read" or "fp = &my_read". via is the field with a
+// leading "." whether it is accessed by "." or "->", or the pointer itself.
+type binding struct {
+	via string
+	fun string
+}
+
+var (
+	re_bind_field = regexp.MustCompile(`(?:\.|->)\s*(\w+)\s*=\s*&?\s*([A-Za-z_]\w*)\s*(?:[,;}]|$)`)
+	re_bind_ptr   = regexp.MustCompile(`(?:^|[^\w.>\s])\s*([A-Za-z_]\w*)\s*=\s*&?\s*([A-Za-z_]\w*)\s*;`)
+	re_bind_decl  = regexp.MustCompile(`\(\s*\*\s*(\w+)\s*\)\s*\([^)]*\)\s*=\s*&?\s*([A-Za-z_]\w*)\s*[,;]`)
+	re_call_field = regexp.MustCompile(`(?:\.|->)\s*(\w+)\s*\(`)
+	re_call_ptr   = regexp.MustCompile(`\(\s*\*\s*(?:\w+\s*(?:\.|->)\s*)*(\w+)\s*\)\s*\(`)
+
+	re_ident_before = regexp.MustCompile(`(\w+)[\s*]*$`)
+)
+
+// findBinds returns the functions, or any other names, assigned to fields
+// and pointers in ln. Whether the name is a function is only known when
+// the callers of the function are searched.
+func findBinds(ln string) []binding {
+	binds := []binding{}
+	for _, match := range re_bind_field.FindAllStringSubmatch(ln, -1) {
+		binds = append(binds, binding{"." + match[1], match[2]})
+	}
+	for _, match := range re_bind_ptr.FindAllStringSubmatch(ln, -1) {
+		binds = append(binds, binding{match[1], match[2]})
+	}
+	for _, match := range re_bind_decl.FindAllStringSubmatch(ln, -1) {
+		binds = append(binds, binding{match[1], match[2]})
+	}
+	return binds
+}
+
+// findIndirect returns the fields called in ln, with a leading ".", and the
+// pointers called as "(*fp)(...)". A pointer declared like "int (*fp)(int)"
+// is not called.
+func findIndirect(ln string) []string {
+	indirect := []string{}
+	for _, match := range re_call_field.FindAllStringSubmatch(ln, -1) {
+		indirect = append(indirect, "."+match[1])
+	}
+	for _, match := range re_call_ptr.FindAllStringSubmatchIndex(ln, -1) {
+		words := re_ident_before.FindStringSubmatch(ln[:match[0]])
+		if words != nil && words[1] != "return" {
+			continue
+		}
+		indirect = append(indirect, ln[match[2]:match[3]])
+	}
+	return indirect
+}
+
+// findPointers returns the function pointers declared in ln, e.g. "fp" of
+// "int (*fp)(int);", which may be bound by a plain assignment "fp = f;".
+// The pointers declared with a typedef of a function pointer are not known.
+func findPointers(ln string) []string {
+	pointers := []string{}
+	for _, match := range re_call_ptr.FindAllStringSubmatchIndex(ln, -1) {
+		words := re_ident_before.FindStringSubmatch(ln[:match[0]])
+		if words == nil || words[1] == "return" {
+			continue
+		}
+		pointers = append(pointers, ln[match[2]:match[3]])
+	}
+	return pointers
+}
+
+// addPointerCalls adds the calls "fp(...)" of the pointers bound in the
+// file to the indirect calls of each line, as they look like direct calls.
+func addPointerCalls(lines []lineIdents) {
+	pointers := make(map[string]bool)
+	for _, ln := range lines {
+		for _, bind := range ln.binds {
+			if bind.via[0] != '.' {
+				pointers[bind.via] = true
+			}
+		}
+	}
+	for i := range lines {
+		for _, name := range lines[i].calls {
+			if pointers[name] {
+				lines[i].indirect = append(lines[i].indirect, name)
+			}
+		}
+	}
+}
+
+// withBinds adds the bindings and the indirect calls found by the raw scan
+// to the lines of clang, whose initializers do not simply name the fields.
+func withBinds(lines, raw []lineIdents) []lineIdents {
+	extra := make(map[uint32]lineIdents)
+	for _, ln := range raw {
+		if len(ln.binds) > 0 || len(ln.indirect) > 0 || len(ln.pointers) > 0 {
+			extra[ln.line] = ln
+		}
+	}
+
+	result := []lineIdents{}
+	for _, ln := range lines {
+		if e, ok := extra[ln.line]; ok {
+			ln.binds, ln.indirect, ln.pointers = e.binds, e.indirect, e.pointers
+			delete(extra, ln.line)
+		}
+		result = append(result, ln)
+	}
+	for _, ln := range raw {
+		if e, ok := extra[ln.line]; ok {
+			result = append(result, lineIdents{e.line, e.decl, []string{}, []RefKind{}, []string{}, e.indirect, e.binds, e.pointers})
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].line < result[j].line
+	})
+	return result
+}
+
+// Bindings returns the distinct fields and pointers fun is assigned to. A
+// name assigned like "read = f;" is a pointer only when it is declared as a
+// function pointer somewhere, not a variable which happens to be assigned.
+func (idx *Index) Bindings(fun string) []string {
+	seen := make(map[string]bool)
+	vias := []string{}
+	for _, via := range idx.binds[fun] {
+		if via[0] != '.' && !idx.pointers[via] {
+			continue
+		}
+		if !seen[via] {
+			seen[via] = true
+			vias = append(vias, via)
+		}
+	}
+	return vias
+}
+
+// LookupIndirect returns the occurrences of the calls through a field or a
+// pointer named via.
+func (idx *Index) LookupIndirect(via string) []Occurrence {
+	return idx.indirect[via]
+}
+
+// readIndirect adds the functions calling t.callee through the fields and
+// the pointers it is assigned to, which are only possible callers.
+func (t *Trace) readIndirect() {
+
+	for _, via := range t.index.Bindings(baseName(t.callee.Fun)) {
+
+		var last_decl_line uint32 = 1
+		last_file := ""
+
+		for _, occ := range t.index.LookupIndirect(via) {
+			if t.pool.Cancelled() {
+				t.pool.Truncate(t)
+				return
+			}
+			if occ.file != last_file {
+				last_file = occ.file
+				last_decl_line = 1
+			}
+			last_decl_line = t.goWalk(occ, last_decl_line, fmt.Sprintf(INDIRECTMARK, via))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package trace
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindBinds(t *testing.T) {
+
+	cases := []struct {
+		ln    string
+		binds []binding
+	}{
+		{"\t.read = my_read,", []binding{{".read", "my_read"}}},
+		{"\tdev->ops->write = &my_write;", []binding{{".write", "my_write"}}},
+		{"\thandler = on_event;", []binding{{"handler", "on_event"}}},
+		{"\tvoid (*cb)(int) = done;", []binding{{"cb", "done"}}},
+		{"\tif (a == b) {", []binding{}},
+	}
+
+	for _, c := range cases {
+		if binds := findBinds(c.ln); !reflect.DeepEqual(binds, c.binds) {
+			t.Errorf("Binds of %q are %v.", c.ln, binds)
+		}
+	}
+
+}
+
+func TestFindIndirect(t *testing.T) {
+
+	cases := []struct {
+		ln       string
+		indirect []string
+	}{
+		{"\treturn dev->ops->read(0);", []string{".read"}},
+		{"\treturn (*cb)(1) + s.fn(2);", []string{".fn", "cb"}},
+		{"\t(*dev->cb)(1);", []string{"cb"}},
+		{"\tint (*cb)(int);", []string{}},
+	}
+
+	for _, c := range cases {
+		if indirect := findIndirect(c.ln); !reflect.DeepEqual(indirect, c.indirect) {
+			t.Errorf("Indirect calls of %q are %v.", c.ln, indirect)
+		}
+	}
+
+}
+
+func TestBindingsDeclared(t *testing.T) {
+
+	if pointers := findPointers("\tstatic int (*hook)(int) = NULL;"); !reflect.DeepEqual(pointers, []string{"hook"}) {
+		t.Errorf("Pointers are %v.", pointers)
+	}
+	if pointers := findPointers("\treturn (*hook)(1);"); len(pointers) != 0 {
+		t.Errorf("A call declares %v.", pointers)
+	}
+
+	idx := newIndex(nil)
+	idx.add("a.c", nil, []lineIdents{
+		{1, -1, []string{}, []RefKind{}, []string{}, nil, nil, findPointers("int (*hook)(int);")},
+		{5, -1, []string{}, []RefKind{}, []string{}, nil, findBinds("\thook = on_event;"), nil},
+		{6, -1, []string{}, []RefKind{}, []string{}, nil, findBinds("\tread = read_all;"), nil},
+		{7, -1, []string{}, []RefKind{}, []string{}, nil, findBinds("\tdev->read = read_all;"), nil},
+	}, nil, false)
+
+	if vias := idx.Bindings("on_event"); !reflect.DeepEqual(vias, []string{"hook"}) {
+		t.Errorf("Bindings of on_event are %v.", vias)
+	}
+	// read is not declared as a pointer, so only the field is a binding
+	if vias := idx.Bindings("read_all"); !reflect.DeepEqual(vias, []string{".read"}) {
+		t.Errorf("Bindings of read_all are %v.", vias)
+	}
+
+}
@@ -0,0 +1,258 @@
+package trace
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+type tokenKind uint8
+
+// Kinds of token. The comments are not tokens.
+const (
+	TOKENIDENT  tokenKind = iota
+	TOKENNUMBER           // A number as the preprocessor takes it, e.g. "1'000" or "0x1p-3"
+	TOKENSTRING           // With its prefix, e.g. `L"a"` or `R"x(a)x"` which may span lines
+	TOKENCHAR
+	TOKENPUNCT // One byte such as "{" or "#"
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line uint32
+	col  int // From 1, in bytes
+}
+
+// tokenizer reads the tokens of a file line by line, keeping a comment or a
+// literal which goes on to the next line. code is the line without comments
+// and literals, where a block comment is left as a space and the other bytes
+// are at the same place as far as no literal or comment is removed before
+// them.
+type tokenizer struct {
+	rd  *bufio.Reader
+	cxx bool // Raw strings are only in C++
+
+	line   uint32
+	code   string
+	tokens []token // The tokens which end on the line
+
+	comment     bool   // Inside "/* */"
+	lineComment bool   // Inside "//" continued by a backslash at the end of the line
+	raw         string // The end of a raw string, e.g. `)delim"`
+	quote       byte   // Inside a literal continued by a backslash at the end of the line
+	open        token  // The literal going on
+}
+
+func newTokenizer(r io.Reader, cxx bool) *tokenizer {
+	return &tokenizer{rd: bufio.NewReader(r), cxx: cxx}
+}
+
+// scan lexes the next line, and returns false at the end of the file.
+func (tz *tokenizer) scan() bool {
+	ln, err := tz.rd.ReadString('\n')
+	if ln == "" && err != nil {
+		return false
+	}
+	tz.line += 1
+	tz.lex(strings.TrimSuffix(strings.TrimSuffix(ln, "\n"), "\r"))
+	return true
+}
+
+// lex sets tz.code and tz.tokens from ln. A quote escaped by a backslash
+// out of the literals is dropped as in a macro.
+func (tz *tokenizer) lex(ln string) {
+
+	code := make([]byte, 0, len(ln))
+	tz.tokens = nil
+
+	for i := 0; i < len(ln); {
+
+		switch {
+		case tz.lineComment:
+			i = len(ln)
+
+		case tz.comment:
+			j := strings.Index(ln[i:], "*/")
+			if j < 0 {
+				i = len(ln)
+				continue
+			}
+			i += j + 2
+			tz.comment = false
+
+		case tz.raw != "":
+			j := strings.Index(ln[i:], tz.raw)
+			if j < 0 {
+				tz.open.text += ln[i:]
+				i = len(ln)
+				continue
+			}
+			tz.open.text += ln[i : i+j+len(tz.raw)]
+			tz.tokens = append(tz.tokens, tz.open)
+			i += j + len(tz.raw)
+			tz.raw = ""
+
+		case tz.quote != 0:
+			i = tz.literal(ln, i)
+
+		default:
+			c := ln[i]
+			next := byte(0)
+			if i+1 < len(ln) {
+				next = ln[i+1]
+			}
+
+			switch {
+			case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f':
+				code = append(code, c)
+				i += 1
+
+			case c == '/' && next == '/':
+				tz.lineComment = true
+				i = len(ln)
+
+			case c == '/' && next == '*':
+				// A comment separates the tokens around it as a space does
+				code = append(code, ' ')
+				tz.comment = true
+				i += 2
+
+			case c == '\\' && (next == '"' || next == '\''):
+				i += 2
+
+			case isWordByte(c) && !isDigit(c):
+				j := i
+				for j < len(ln) && isWordByte(ln[j]) {
+					j += 1
+				}
+				word := ln[i:j]
+				if j < len(ln) && ln[j] == '"' && tz.cxx && isRawPrefix(word) {
+					if delim, ok := rawDelim(ln[j+1:]); ok {
+						tz.open = token{TOKENSTRING, ln[i : j+len(delim)+2], tz.line, i + 1}
+						tz.raw = ")" + delim + "\""
+						i = j + len(delim) + 2
+						continue
+					}
+				}
+				if j < len(ln) && (ln[j] == '"' || ln[j] == '\'') && isLiteralPrefix(word) {
+					tz.open = token{TOKENSTRING, word, tz.line, i + 1}
+					if ln[j] == '\'' {
+						tz.open.kind = TOKENCHAR
+					}
+					tz.quote = ln[j]
+					tz.open.text += ln[j : j+1]
+					i = j + 1
+					continue
+				}
+				tz.tokens = append(tz.tokens, token{TOKENIDENT, word, tz.line, i + 1})
+				code = append(code, word...)
+				i = j
+
+			case isDigit(c) || (c == '.' && isDigit(next)):
+				j := numberEnd(ln, i)
+				tz.tokens = append(tz.tokens, token{TOKENNUMBER, ln[i:j], tz.line, i + 1})
+				code = append(code, strings.Replace(ln[i:j], "'", "", -1)...)
+				i = j
+
+			case c == '"' || c == '\'':
+				tz.open = token{TOKENSTRING, ln[i : i+1], tz.line, i + 1}
+				if c == '\'' {
+					tz.open.kind = TOKENCHAR
+				}
+				tz.quote = c
+				i += 1
+
+			default:
+				tz.tokens = append(tz.tokens, token{TOKENPUNCT, ln[i : i+1], tz.line, i + 1})
+				code = append(code, c)
+				i += 1
+			}
+		}
+	}
+
+	// Only a backslash goes on to the next line
+	if !strings.HasSuffix(ln, "\\") {
+		tz.lineComment = false
+		if tz.quote != 0 {
+			tz.tokens = append(tz.tokens, tz.open)
+			tz.quote = 0
+		}
+	} else if tz.quote != 0 {
+		tz.open.text += "\n"
+	}
+	if tz.raw != "" {
+		tz.open.text += "\n"
+	}
+
+	tz.code = string(code)
+}
+
+// literal reads the rest of a string or a character literal from ln[i].
+func (tz *tokenizer) literal(ln string, i int) int {
+	start := i
+	for i < len(ln) {
+		switch ln[i] {
+		case '\\':
+			i += 2
+		case tz.quote:
+			tz.open.text += ln[start : i+1]
+			tz.tokens = append(tz.tokens, tz.open)
+			tz.quote = 0
+			return i + 1
+		default:
+			i += 1
+		}
+	}
+	tz.open.text += ln[start:]
+	return len(ln)
+}
+
+// numberEnd returns the end of the number at ln[i]. A quote between digits
+// like 1'000 is a digit separator.
+func numberEnd(ln string, i int) int {
+	for i += 1; i < len(ln); i++ {
+		c := ln[i]
+		switch {
+		case isWordByte(c) || c == '.':
+		case c == '\'' && i+1 < len(ln) && isWordByte(ln[i+1]):
+		case (c == '+' || c == '-') && strings.ContainsRune("eEpP", rune(ln[i-1])):
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// rawDelim returns the delimiter of a raw string whose quote is before s.
+func rawDelim(s string) (string, bool) {
+	j := strings.IndexByte(s, '(')
+	if j < 0 || j > 16 || strings.ContainsAny(s[:j], " \t)\\\"") {
+		return "", false
+	}
+	return s[:j], true
+}
+
+func isRawPrefix(word string) bool {
+	switch word {
+	case "R", "u8R", "uR", "UR", "LR":
+		return true
+	}
+	return false
+}
+
+func isLiteralPrefix(word string) bool {
+	switch word {
+	case "L", "u", "U", "u8":
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || isDigit(c) || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
@@ -0,0 +1,165 @@
+package trace
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tokenize returns the code of each line of src and the tokens of src.
+func tokenize(src string, cxx bool) ([]string, []token) {
+	tz := newTokenizer(strings.NewReader(src), cxx)
+	code := []string{}
+	tokens := []token{}
+	for tz.scan() {
+		code = append(code, tz.code)
+		tokens = append(tokens, tz.tokens...)
+	}
+	return code, tokens
+}
+
+func TestTokenizer(t *testing.T) {
+
+	src := `a = R"x(
+)" }
+)x" + b; /* c
+*/ d = "e\\"; f = '\''; g = 0x1'ff'ffUL; h = L'i';
+j = "k \
+l" m; n = u8R"(o)" p;
+s = "/*"; t(); /* u */ v();
+*/ w { /* x */ }
+// y \
+z {`
+
+	expected := []string{
+		"a = ", "", " + b;  ", " d = ; f = ; g = 0x1ffffUL; h = ;", "j = ", " m; n =  p;",
+		"s = ; t();   v();", "*/ w {   }", "", "",
+	}
+
+	code, tokens := tokenize(src, true)
+	if !reflect.DeepEqual(code, expected) {
+		t.Errorf("Code is %q.", code)
+	}
+
+	literals := []token{}
+	for _, tk := range tokens {
+		if tk.kind == TOKENSTRING || tk.kind == TOKENCHAR {
+			literals = append(literals, tk)
+		}
+	}
+	expected_literals := []token{
+		{TOKENSTRING, "R\"x(\n)\" }\n)x\"", 1, 5},
+		{TOKENSTRING, `"e\\"`, 4, 8},
+		{TOKENCHAR, `'\''`, 4, 19},
+		{TOKENCHAR, `L'i'`, 4, 46},
+		{TOKENSTRING, "\"k \\\nl\"", 5, 5},
+		{TOKENSTRING, `u8R"(o)"`, 6, 11},
+		{TOKENSTRING, `"/*"`, 7, 5},
+	}
+	if !reflect.DeepEqual(literals, expected_literals) {
+		t.Errorf("Literals are %v.", literals)
+	}
+
+	for _, tk := range tokens {
+		if tk.text == "w" && (tk.line != 8 || tk.col != 4) {
+			t.Errorf("w is at %d:%d.", tk.line, tk.col)
+		}
+		if tk.text == "0x1'ff'ffUL" && tk.kind != TOKENNUMBER {
+			t.Errorf("A number is %v.", tk)
+		}
+	}
+
+	// No raw strings in C
+	if code, _ := tokenize(`R"(a)" b`, false); code[0] != "R b" {
+		t.Errorf("%q failed.", code[0])
+	}
+
+}
+
+// lexCode returns a line without its comments and literals, lexed by itself.
+func lexCode(s string) string {
+	tz := newTokenizer(nil, true)
+	tz.lex(s)
+	return tz.code
+}
+
+// braceDepth returns the lowest and the last depth of the braces of tokens.
+func braceDepth(tokens []token) (int, int) {
+	depth, lowest := 0, 0
+	for _, tk := range tokens {
+		if tk.kind != TOKENPUNCT {
+			continue
+		}
+		switch tk.text {
+		case "{":
+			depth += 1
+		case "}":
+			depth -= 1
+		}
+		if depth < lowest {
+			lowest = depth
+		}
+	}
+	return lowest, depth
+}
+
+func FuzzTokenizer(f *testing.F) {
+
+	f.Add(`"/*" { /* " */ }`)
+	f.Add(`"\\" } '\\' {`)
+	f.Add(`*/ } /* {`)
+	f.Add(`R"x( } )x" ) } '{' 1'000 \`)
+
+	f.Fuzz(func(t *testing.T, s string) {
+
+		dir := t.TempDir()
+
+		// Anything is lexed and scoped without a panic
+		tokenize(s, false)
+		tokenize(s, true)
+		for _, name := range []string{"any.c", "any.cpp"} {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+				t.Fatal(err)
+			}
+			GetDeclsByRaw(path)
+		}
+
+		// Valid C holding s in its literals and comments
+		line := strings.NewReplacer("\n", " ", "\r", " ").Replace(s)
+		char := strconv.QuoteRuneToASCII([]rune(s + "x")[0])
+		src := "int f(void) {\n" +
+			"\tchar *s = " + strconv.QuoteToASCII(s) + ";\n" +
+			"\tchar c = " + char + ";\n" +
+			"\t/* " + strings.Replace(s, "*/", "* /", -1) + " */\n" +
+			"\tif (c) {\n" +
+			"\t\t// " + line + "\n" +
+			"\t\treturn 1;\n" +
+			"\t}\n" +
+			"\treturn 0;\n" +
+			"}\n"
+
+		for _, cxx := range []bool{false, true} {
+			_, tokens := tokenize(src, cxx)
+			if lowest, last := braceDepth(tokens); lowest < 0 || last != 0 {
+				t.Errorf("Braces go to %d and end at %d in %q.", lowest, last, src)
+			}
+		}
+
+		// The scope never goes below the file, which would be a warning
+		for _, name := range []string{"f.c", "f.cpp"} {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			decls, err := GetDeclsByRaw(path)
+			if err != nil || len(decls) != 1 || decls[0].Name != "f" {
+				t.Errorf("%v %v in %q.", decls, err, src)
+			}
+		}
+	})
+
+}
@@ -0,0 +1,158 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+// Storage is the set of storage class keywords of a function.
+type Storage uint8
+
+const (
+	STORAGESTATIC Storage = 1 << iota
+	STORAGEEXTERN
+	STORAGEINLINE
+)
+
+const (
+	AMBIGUOUSMARK = " ? ambiguous, %d definitions"
+)
+
+func (s Storage) String() string {
+	words := []string{}
+	if s&STORAGESTATIC != 0 {
+		words = append(words, "static")
+	}
+	if s&STORAGEEXTERN != 0 {
+		words = append(words, "extern")
+	}
+	if s&STORAGEINLINE != 0 {
+		words = append(words, "inline")
+	}
+	return strings.Join(words, " ")
+}
+
+// headStorage reads the storage class from the words of head before the
+// name of the function.
+func headStorage(head string) Storage {
+	var storage Storage
+	for _, word := range strings.Fields(strings.Split(head, "(")[0]) {
+		switch word {
+		case "static":
+			storage |= STORAGESTATIC
+		case "extern":
+			storage |= STORAGEEXTERN
+		case "inline", "__inline", "__inline__":
+			storage |= STORAGEINLINE
+		}
+	}
+	return storage
+}
+
+// clangStorage is the storage class of a function declared at cursor.
+func clangStorage(cursor clang.Cursor) Storage {
+	var storage Storage
+	switch cursor.StorageClass() {
+	case clang.SC_Static:
+		storage |= STORAGESTATIC
+	case clang.SC_Extern:
+		storage |= STORAGEEXTERN
+	}
+	if cursor.IsFunctionInlined() {
+		storage |= STORAGEINLINE
+	}
+	return storage
+}
+
+var re_include = regexp.MustCompile(`^\s*#\s*include\s*["<]([^">]+)[">]`)
+
+// readIncludes returns the files included by path as they are written.
+func readIncludes(path string) []string {
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer fd.Close()
+
+	includes := []string{}
+
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		if match := re_include.FindStringSubmatch(sc.Text()); match != nil {
+			includes = append(includes, match[1])
+		}
+	}
+	return includes
+}
+
+// includes tells whether file includes header. The include paths are not
+// known, so "net/packet.h" is any header whose path ends with it.
+func (idx *Index) includes(file, header string) bool {
+	header = filepath.ToSlash(header)
+	for _, inc := range idx.incs[file] {
+		if header == inc || strings.HasSuffix(header, "/"+inc) {
+			return true
+		}
+	}
+	return false
+}
+
+// visible tells whether the function defined at def can be called from
+// file, i.e. it is not static or file is its translation unit.
+func (idx *Index) visible(def Occurrence, file string) bool {
+	if idx.decls[def.file][def.decl].Storage&STORAGESTATIC == 0 || file == def.file {
+		return true
+	}
+	if isHeader(def.file) {
+		return idx.includes(file, def.file)
+	}
+	return isHeader(file) && idx.includes(def.file, file)
+}
+
+// definition returns the definition of callee in the index.
+func (idx *Index) definition(callee Callee) (Occurrence, bool) {
+	for _, def := range idx.funcs[baseName(callee.Fun)] {
+		if def.file == callee.File && def.line == callee.Line {
+			return def, true
+		}
+	}
+	return Occurrence{}, false
+}
+
+// linkage tells whether the name of t.callee at occ may be t.callee rather
+// than another function of the same name. The mark is set when several
+// functions may be called there.
+func (t *Trace) linkage(occ Occurrence) (bool, string) {
+
+	def, ok := t.index.definition(t.callee)
+	if !ok {
+		return true, ""
+	}
+	if !t.index.visible(def, occ.file) {
+		return false, ""
+	}
+
+	candidates := 0
+	for _, other := range t.index.funcs[baseName(t.callee.Fun)] {
+		if !t.index.visible(other, occ.file) {
+			continue
+		}
+		// A static function of the file hides the others
+		if other.file == occ.file && other != def && t.index.decls[other.file][other.decl].Storage&STORAGESTATIC != 0 {
+			return false, ""
+		}
+		candidates += 1
+	}
+
+	if candidates > 1 {
+		return true, fmt.Sprintf(AMBIGUOUSMARK, candidates)
+	}
+	return true, ""
+}
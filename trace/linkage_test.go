@@ -0,0 +1,49 @@
+package trace
+
+import (
+	"testing"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+func TestHeadStorage(t *testing.T) {
+
+	cases := []struct {
+		head    string
+		storage Storage
+	}{
+		{"int init(void) {", 0},
+		{"static int init(void) {", STORAGESTATIC},
+		{"static inline struct buf *get(struct buf *b) {", STORAGESTATIC | STORAGEINLINE},
+		{"extern int f(int static_len) {", STORAGEEXTERN},
+	}
+
+	for _, c := range cases {
+		if storage := headStorage(c.head); storage != c.storage {
+			t.Errorf("Storage of %q is %q.", c.head, storage)
+		}
+	}
+
+}
+
+func TestLinkage(t *testing.T) {
+
+	idx := newIndex(nil)
+	idx.add("a.c", Decls{Decl{3, clang.Cursor_FunctionDecl, "init", "static int init(void) {", STORAGESTATIC, 1}}, nil, []string{"lib/init.h"}, false)
+	idx.add("b.c", Decls{Decl{3, clang.Cursor_FunctionDecl, "init", "int init(void) {", 0, 1}}, nil, nil, false)
+	idx.add("c.c", Decls{Decl{3, clang.Cursor_FunctionDecl, "init", "int init(void) {", 0, 1}}, nil, nil, false)
+
+	if !idx.visible(idx.funcs["init"][0], "src/lib/init.h") || idx.visible(idx.funcs["init"][0], "b.c") {
+		t.Errorf("The static init of a.c is visible only from a.c and its headers.")
+	}
+
+	tr := &Trace{session: &session{index: idx}, callee: Callee{"init", "b.c", 3, ""}}
+
+	if ok, _ := tr.linkage(Occurrence{"a.c", 10, 0, REFCALL}); ok {
+		t.Errorf("init in a.c calls its own static init.")
+	}
+	if ok, mark := tr.linkage(Occurrence{"d.c", 10, 0, REFCALL}); !ok || mark == "" {
+		t.Errorf("init in d.c is either of b.c and c.c.")
+	}
+
+}
@@ -0,0 +1,183 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+// callerDecl returns the function enclosing occ when it is a caller of
+// t.callee in the sense of goWalk, i.e. a function scope in a source file.
+func (t *Trace) callerDecl(occ Occurrence) (Decl, bool) {
+
+	if occ.decl < 0 || isHeader(occ.file) {
+		return Decl{}, false
+	}
+
+	decl := t.index.decls[occ.file][occ.decl]
+	if decl.Kind != clang.Cursor_FunctionDecl || (occ.file == t.callee.File && decl.Line == t.callee.Line) {
+		return Decl{}, false
+	}
+
+	return decl, true
+}
+
+// pathSearch is the state of one Paths search shared by its nodes.
+type pathSearch struct {
+	ctx    context.Context
+	source string
+	cut    bool
+	dead   map[string]bool // Functions which cannot reach source within some depth
+}
+
+// readPath searches callers of t.callee until ps.source is reached and keeps
+// only the nodes on the way to it. A caller which is already on the chain
+// is not followed again. blocked tells whether a caller was skipped for it,
+// so that a function found not to reach the source is only remembered when
+// the result does not depend on the chain.
+func (t *Trace) readPath(ps *pathSearch) (found bool, blocked bool) {
+
+	key := fmt.Sprintf("%s@%d#%d", t.callee.File, t.callee.Line, t.maxlevel-t.level)
+	if ps.dead[key] {
+		return false, false
+	}
+
+	expanded := make(map[string]bool)
+
+	h := fmt.Sprintf("%s-%d-", strings.Repeat(" ", t.level-1), t.level)
+
+	for _, occ := range t.index.Lookup(baseName(t.callee.Fun)) {
+
+		if ps.ctx.Err() != nil {
+			t.result = markResult(t.result, TRUNCMARK)
+			ps.cut = true
+			return found, true
+		}
+
+		if occ.kinds&t.refkinds == 0 {
+			continue
+		}
+		if ok, _ := t.linkage(occ); !ok {
+			continue
+		}
+
+		decl, ok := t.callerDecl(occ)
+		if !ok {
+			continue
+		}
+
+		callee := Callee{decl.Name, occ.file, decl.Line, decl.Head}
+
+		key := fmt.Sprintf("%s@%d", occ.file, decl.Line)
+		if expanded[key] {
+			continue
+		}
+		if t.onPath(callee) {
+			blocked = true
+			continue
+		}
+		expanded[key] = true
+
+		result := fmt.Sprintf("%s %s (%s) %s@L%d in \x1b[34m%s\x1b[0m function scope.\n",
+			h, t.callee.Fun, occ.kinds&t.refkinds, occ.file, occ.line, t.index.label(occ.file, decl))
+
+		trace := t.newChild(Entry{occ.file, occ.line}, callee, result)
+
+		if matchName(decl.Name, ps.source) {
+			t.addNode(trace)
+			found = true
+			continue
+		}
+
+		if trace.level <= trace.maxlevel {
+			ok, cycle := trace.readPath(ps)
+			blocked = blocked || cycle
+			if ok {
+				t.addNode(trace)
+				found = true
+			}
+		}
+	}
+
+	if !found && !blocked {
+		ps.dead[key] = true
+	}
+	return found, blocked
+}
+
+// findTargets resolves TARGET given either as a function name or FILE@LINE.
+func (t *Trace) findTargets(target string) ([]Callee, error) {
+
+	targets := []Callee{}
+
+	if strings.Contains(target, "@") {
+		target_slice := strings.Split(target, "@")
+		file := target_slice[0]
+		line, err := strconv.ParseUint(strings.TrimPrefix(target_slice[1], "L"), 10, 32)
+		if err != nil {
+			return nil, &OptionError{"target", target}
+		}
+
+		decls := t.index.decls[file]
+		i := findDecl(decls, uint32(line))
+		if i < 0 {
+			return nil, &EntryError{target, "is not in any function or struct"}
+		}
+		targets = append(targets, Callee{decls[i].Name, file, decls[i].Line, decls[i].Head})
+		return targets, nil
+	}
+
+	for _, def := range t.index.Functions(target) {
+		decl := t.index.decls[def.file][def.decl]
+		targets = append(targets, Callee{decl.Name, def.file, decl.Line, decl.Head})
+	}
+	if len(targets) == 0 {
+		return nil, &EntryError{target, "is not defined in any source file"}
+	}
+	return targets, nil
+}
+
+// Paths searches every call chain from source to target, where target is
+// a function name or FILE@LINE. The chains are merged into a tree rooted at
+// the target, whose nodes are the targets found.
+func Paths(ctx context.Context, opts Options, source, target string) (*Tree, error) {
+
+	s, err := newSession(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	ps := &pathSearch{ctx, source, false, make(map[string]bool)}
+
+	ent := fmt.Sprintf("Go search call paths from %s to %s.\n", source, target)
+	root := &Trace{s, Entry{}, Callee{}, 1, ent, nil, nil, nil}
+
+	targets, err := root.findTargets(target)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, callee := range targets {
+
+		result := fmt.Sprintf("-1- Target %s@L%d in \x1b[34m%s\x1b[0m function scope.\n",
+			callee.File, callee.Line, callee.Fun)
+
+		node := root.newChild(Entry{callee.File, callee.Line}, callee, result)
+
+		if found, _ := node.readPath(ps); found {
+			root.addNode(node)
+		}
+	}
+
+	sortTree(root, opts.Sort)
+
+	return &Tree{root, ps.cut, s.index.Warnings()}, nil
+}
@@ -0,0 +1,152 @@
+package trace
+
+import (
+	"context"
+	"sync"
+)
+
+const TRUNCMARK = " ... truncated"
+
+// workerPool walks trace nodes on a fixed number of workers, one level of the tree
+// at a time. Nodes submitted while a level is walked are claimed only after
+// the level completes and in tree order, so the node which expands a shared
+// function does not depend on the scheduling of the workers.
+type workerPool struct {
+	ctx   context.Context
+	jobs  int
+	mtx   sync.Mutex
+	queue []*Trace
+	next  map[*Trace]bool
+	first []*Trace // Submitted before Wait, which have no walked parent
+	wait  bool
+	cut   bool
+}
+
+func newWorkerPool(ctx context.Context, jobs int) *workerPool {
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &workerPool{ctx: ctx, jobs: jobs, next: make(map[*Trace]bool)}
+}
+
+func (p *workerPool) Submit(t *Trace) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.ctx.Err() != nil {
+		p.truncate(t)
+		return
+	}
+
+	p.next[t] = true
+	if !p.wait {
+		p.first = append(p.first, t)
+	}
+}
+
+// Cancelled tells whether the search should stop as soon as possible.
+func (p *workerPool) Cancelled() bool {
+	return p.ctx.Err() != nil
+}
+
+// truncate marks t as a node whose callers or callees were not searched.
+// The caller must hold p.mtx.
+func (p *workerPool) truncate(t *Trace) {
+	t.result = markResult(t.result, TRUNCMARK)
+	p.cut = true
+}
+
+// Truncate is truncate for the nodes being walked.
+func (p *workerPool) Truncate(t *Trace) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.truncate(t)
+}
+
+// Truncated tells whether any node was left unsearched.
+func (p *workerPool) Truncated() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.cut
+}
+
+func (p *workerPool) worker() {
+	for {
+		p.mtx.Lock()
+		if len(p.queue) == 0 || p.ctx.Err() != nil {
+			p.mtx.Unlock()
+			return
+		}
+		t := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mtx.Unlock()
+
+		t.walk()
+	}
+}
+
+// advance sorts the children of the walked level and claims the submitted
+// ones in that order. It returns the nodes to walk as the next level.
+func (p *workerPool) advance(walked []*Trace) []*Trace {
+
+	order := p.first
+	p.first = nil
+
+	for _, parent := range walked {
+		sortNodes(parent.nodes, SORTFILE)
+		order = append(order, parent.nodes...)
+	}
+
+	level := []*Trace{}
+	for _, t := range order {
+		if p.next[t] {
+			delete(p.next, t)
+			if t.parent.claim(t) {
+				level = append(level, t)
+			}
+		}
+	}
+
+	return level
+}
+
+// Wait walks the tree until every submitted node is walked or the context
+// is done. The nodes left unwalked are marked as truncated.
+func (p *workerPool) Wait() {
+
+	p.mtx.Lock()
+	p.wait = true
+	p.mtx.Unlock()
+
+	level := p.advance(nil)
+
+	for len(level) > 0 && p.ctx.Err() == nil {
+
+		p.queue = level
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < p.jobs; i++ {
+			wg.Add(1)
+			go func() {
+				p.worker()
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+
+		level = p.advance(level)
+	}
+
+	p.mtx.Lock()
+	for _, t := range p.queue {
+		p.truncate(t)
+	}
+	for _, t := range level {
+		p.truncate(t)
+	}
+	for t := range p.next {
+		p.truncate(t)
+	}
+	p.queue = nil
+	p.mtx.Unlock()
+}
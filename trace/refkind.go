@@ -0,0 +1,216 @@
+package trace
+
+import "strings"
+
+// RefKind is the set of the ways a name is referred to on a line.
+type RefKind uint8
+
+const (
+	REFCALL  RefKind = 1 << iota // Called directly, "f(...)"
+	REFADDR                      // Address taken, "&f", "cb = f" or "g(f)"
+	REFDECL                      // Declared or defined, "int f(void)"
+	REFOTHER                     // Another name such as "s->f" or a local "int f"
+)
+
+// DEFAULTREFKINDS are shown unless Options.RefKinds is given.
+const DEFAULTREFKINDS = "call,addr,decl"
+
+var refKindNames = []struct {
+	kind RefKind
+	name string
+}{
+	{REFCALL, "call"},
+	{REFADDR, "addr"},
+	{REFDECL, "decl"},
+	{REFOTHER, "other"},
+}
+
+func (k RefKind) String() string {
+	names := []string{}
+	for _, n := range refKindNames {
+		if k&n.kind != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseRefKinds reads kinds like "call,addr".
+func ParseRefKinds(kinds string) (RefKind, error) {
+	var k RefKind
+	for _, name := range strings.Split(kinds, ",") {
+		found := false
+		for _, n := range refKindNames {
+			if strings.TrimSpace(name) == n.name {
+				k |= n.kind
+				found = true
+			}
+		}
+		if !found {
+			return 0, &OptionError{"ref kind", name}
+		}
+	}
+	return k, nil
+}
+
+// Words before a name which do not make the name declared.
+var nonTypeWords = map[string]bool{
+	"return": true, "else": true, "do": true, "case": true, "goto": true, "sizeof": true,
+}
+
+// Words which are a type, or a part of one, before "*" in a declaration.
+var typeWords = map[string]bool{
+	"void": true, "char": true, "short": true, "int": true, "long": true, "float": true, "double": true,
+	"signed": true, "unsigned": true, "_Bool": true, "bool": true, "auto": true, "const": true, "volatile": true,
+}
+
+// Keywords which are never a name to trace, even before "(" like "if (x)",
+// besides nonTypeWords and typeWords.
+var keywords = map[string]bool{
+	"if": true, "while": true, "for": true, "switch": true, "break": true, "continue": true, "default": true,
+	"_Alignof": true, "alignof": true, "_Alignas": true, "alignas": true, "_Static_assert": true, "static_assert": true,
+	"typeof": true, "__typeof__": true, "__attribute__": true, "asm": true, "__asm__": true, "_Generic": true,
+	"struct": true, "union": true, "enum": true, "typedef": true, "static": true, "extern": true, "inline": true,
+	"register": true, "restrict": true, "class": true, "namespace": true, "template": true, "typename": true,
+	"this": true, "new": true, "delete": true, "throw": true, "try": true, "catch": true, "operator": true,
+	"decltype": true, "noexcept": true, "static_cast": true, "dynamic_cast": true, "const_cast": true,
+	"reinterpret_cast": true, "using": true, "virtual": true, "explicit": true, "constexpr": true,
+}
+
+// isKeyword tells whether word is a keyword of C or C++.
+func isKeyword(word string) bool {
+	return keywords[word] || nonTypeWords[word] || typeWords[word]
+}
+
+// punct tells whether tk is the punctuation p.
+func punct(tk token, p string) bool {
+	return tk.kind == TOKENPUNCT && tk.text == p
+}
+
+// adjacent tells whether b follows a on the line without a space, e.g.
+// the "-" and ">" of "->".
+func adjacent(a, b token) bool {
+	return a.line == b.line && a.col+len(a.text) == b.col
+}
+
+// declared tells whether the tokens before a name declare it, i.e. the
+// statement starts with a type like "static int *f" or "foo_t f". A word
+// before "*" must be a type, a struct or a typedef ending with "_t", as
+// "a * f" is a multiplication otherwise.
+func declared(before []token) bool {
+	start := 0
+	for i, tk := range before {
+		if tk.kind == TOKENPUNCT && strings.Contains(";{}(,", tk.text) {
+			start = i + 1
+		}
+	}
+	stmt := before[start:]
+
+	// The qualifiers of a name like "Foo::"
+	for n := len(stmt); n >= 3 && punct(stmt[n-1], ":") && punct(stmt[n-2], ":") && stmt[n-3].kind == TOKENIDENT; n = len(stmt) {
+		stmt = stmt[:n-3]
+	}
+
+	words := []string{}
+	pointer := false
+	for _, tk := range stmt {
+		switch {
+		case tk.kind == TOKENIDENT:
+			words = append(words, tk.text)
+			pointer = false
+		case punct(tk, "*") || punct(tk, "&"):
+			pointer = true
+		default:
+			return false
+		}
+	}
+
+	if len(words) == 0 || nonTypeWords[words[len(words)-1]] {
+		return false
+	}
+	if !pointer {
+		return true
+	}
+
+	word := words[len(words)-1]
+	if typeWords[word] || strings.HasSuffix(word, "_t") {
+		return true
+	}
+	if len(words) > 1 {
+		switch words[len(words)-2] {
+		case "struct", "union", "enum", "class":
+			return true
+		}
+	}
+	return false
+}
+
+// refKind classifies the name at tokens[i], the tokens of a line.
+func refKind(tokens []token, i int) RefKind {
+	if isKeyword(tokens[i].text) {
+		return REFOTHER
+	}
+
+	before := tokens[:i]
+	after := tokens[i+1:]
+
+	// The last tokens before the name, prev[0] being the nearest
+	prev := []token{{}, {}}
+	for j := 0; j < 2 && j < len(before); j++ {
+		prev[j] = before[len(before)-1-j]
+	}
+	next := token{}
+	if len(after) > 0 {
+		next = after[0]
+	}
+
+	if punct(prev[0], ".") || (punct(prev[0], ">") && punct(prev[1], "-") && adjacent(prev[1], prev[0])) {
+		return REFOTHER
+	}
+
+	// A type before the name, "int f(void)" or "struct s *f;"
+	typed := declared(before)
+
+	// An operator of two bytes like "&&" or "=="
+	double := func(p ...string) bool {
+		for _, q := range p {
+			if punct(prev[1], q) && adjacent(prev[1], prev[0]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case punct(next, "("):
+		if typed {
+			return REFDECL
+		}
+		return REFCALL
+	case typed:
+		return REFOTHER
+	case punct(prev[0], "&") && !double("&"):
+		return REFADDR
+	case punct(prev[0], "=") && !double("=", "!", "<", ">"):
+		return REFADDR
+	case (punct(prev[0], "(") || punct(prev[0], ",")) && (punct(next, ")") || punct(next, ",")):
+		return REFADDR
+	}
+	return REFOTHER
+}
+
+// refKinds returns the kinds of each ident in tokens.
+func refKinds(tokens []token, idents []string) []RefKind {
+	kinds := make([]RefKind, len(idents))
+	for i, tk := range tokens {
+		if tk.kind != TOKENIDENT {
+			continue
+		}
+		for j, ident := range idents {
+			if tk.text == ident {
+				kinds[j] |= refKind(tokens, i)
+			}
+		}
+	}
+	return kinds
+}
@@ -0,0 +1,67 @@
+package trace
+
+import "testing"
+
+func TestRefKind(t *testing.T) {
+
+	cases := []struct {
+		ln   string
+		kind RefKind
+	}{
+		{"\treturn init(dev);", REFCALL},
+		{"\tif (init(dev) < 0)", REFCALL},
+		{"\tstatic int init(struct dev *dev) {", REFDECL},
+		{"\tint init(void);", REFDECL},
+		{"\tregister_cb(&init);", REFADDR},
+		{"\tregister_cb(dev, init);", REFADDR},
+		{"\t.probe = init,", REFADDR},
+		{"\tdev->init(dev);", REFOTHER},
+		{"\tint init = 0;", REFOTHER},
+		{"\tstatic void probe(int init) {", REFOTHER},
+		{"\tinit += 1;", REFOTHER},
+		{"\tx = y * init(z);", REFCALL},
+		{"\ta * init(b);", REFCALL},
+		{"\tn = count * init(id);", REFCALL},
+		{"\treturn *init(x);", REFCALL},
+		{"\tif (a & init(b))", REFCALL},
+		{"\tx = (int) init(y);", REFCALL},
+		{"\tstatic struct dev *init(void) {", REFDECL},
+		{"\tconst char *init(void);", REFDECL},
+		{"\tdev_t *init(void);", REFDECL},
+		{"\tdev_ops init(void);", REFDECL},
+		{"\tint Foo::init(void) {", REFDECL},
+	}
+
+	for _, c := range cases {
+		tz := newTokenizer(nil, true)
+		tz.lex(c.ln)
+		if kinds := refKinds(tz.tokens, []string{"init"}); kinds[0] != c.kind {
+			t.Errorf("%q is %s.", c.ln, kinds[0])
+		}
+	}
+
+	// Keywords before "(" are not calls
+	keywords := []struct {
+		ln   string
+		word string
+	}{
+		{"\tif (x) {", "if"},
+		{"\twhile (x)", "while"},
+		{"\tfor (;;) {", "for"},
+		{"\tswitch (x) {", "switch"},
+		{"\treturn (x);", "return"},
+		{"\tn = sizeof(x);", "sizeof"},
+	}
+	for _, c := range keywords {
+		tz := newTokenizer(nil, true)
+		tz.lex(c.ln)
+		if kinds := refKinds(tz.tokens, []string{c.word}); kinds[0] != REFOTHER {
+			t.Errorf("%s of %q is %s.", c.word, c.ln, kinds[0])
+		}
+	}
+
+	if _, err := ParseRefKinds("call,macro"); err == nil {
+		t.Errorf("macro is not a kind.")
+	}
+
+}
@@ -0,0 +1,378 @@
+package trace
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+// isCxx tells whether path is C++. A .h file is C++ when it declares a
+// class, a namespace or a template at the start of some line.
+func isCxx(path string) bool {
+	switch filepath.Ext(path) {
+	case ".cpp", ".cc", ".cxx", ".hpp", ".hh", ".hxx":
+		return true
+	case ".h":
+	default:
+		return false
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+
+	sc := bufio.NewScanner(fd)
+	for sc.Scan() {
+		if re_cxx_line.MatchString(sc.Text()) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	re_cxx_line   = regexp.MustCompile(`^\s*(class\s+\w+|namespace\b|template\s*<)`)
+	re_access     = regexp.MustCompile(`^\s*(public|private|protected)\s*:([^:]|$)`)
+	re_identifier = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+	re_operator   = regexp.MustCompile(`(?:^|[^\w:])((?:\w+::)*)operator\b\s*(\(\)|[^(]*)`)
+	re_lambda     = regexp.MustCompile(`\[[^\[\]]*\]\s*(\(|\{|mutable\b)`)
+	re_lambda_var = regexp.MustCompile(`(\w+)\s*=\s*\[[^\[\]]*\]\s*(\(|\{|mutable\b)`)
+)
+
+// outerScope is a block whose body holds decls, i.e. a namespace, an extern
+// block, or a class of C++.
+type outerScope struct {
+	name  string // "" unless it qualifies the names inside
+	class bool
+	head  string
+	start uint32
+}
+
+// rawScope follows the braces of a file line by line for GetDeclsByRaw and
+// readIdents, so that both agree on which lines are in a body.
+type rawScope struct {
+	cxx    bool
+	global int
+	module int // Braces of the outer scopes
+	head   []string
+	tokens []token // Of the lines of head
+	start  uint32  // The line where head starts
+	outer  []outerScope
+	anon   bool // In the body of a lambda which is not held by a variable
+}
+
+// inBody tells whether the last line ended inside a function or a struct.
+func (s *rawScope) inBody() bool {
+	return s.global-s.module > 0
+}
+
+func (s *rawScope) reset() {
+	s.global = 0
+	s.module = 0
+	s.outer = nil
+	s.anon = false
+	s.resetHead()
+}
+
+func (s *rawScope) resetHead() {
+	reset(&s.head)
+	s.tokens = nil
+}
+
+// qualifier is the names of the outer scopes joined with "::".
+func (s *rawScope) qualifier() string {
+	names := []string{}
+	for _, o := range s.outer {
+		if o.name != "" {
+			names = append(names, o.name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.Join(names, "::") + "::"
+}
+
+// line follows the braces of the tokens of a line, whose code is real_ln,
+// and returns the decls which end on it. code is false for the lines in the
+// branches of #if not parsed.
+func (s *rawScope) line(real_ln string, tokens []token, code bool, line uint32) Decls {
+
+	decls := Decls{}
+
+	if code && !s.inBody() {
+		// Only the head counts for a body on the same line, "int f() { return 0; }"
+		if isNotFunc(strings.SplitN(real_ln, "{", 2)[0]) {
+			s.resetHead()
+		} else {
+			ln := strings.TrimSpace(real_ln)
+			if s.cxx {
+				ln = strings.TrimSpace(re_access.ReplaceAllString(ln, "$2"))
+			}
+			if ln != "" && strings.TrimSpace(strings.Join(s.head, "")) == "" {
+				s.start = line
+			}
+			s.head = append(s.head, ln)
+			s.tokens = append(s.tokens, tokens...)
+		}
+	}
+
+	if c := countPunct(tokens, "{"); c > 0 {
+
+		if !s.inBody() {
+			// The brace may be on the next line of the head, "namespace geo\n{"
+			name, outer := outerHead(tokens)
+			if !outer {
+				name, outer = outerHead(s.tokens)
+			}
+			if outer {
+				if !s.cxx {
+					name = ""
+				}
+				s.outer = append(s.outer, outerScope{name, false, "", 0})
+				s.module += 1
+				s.resetHead()
+			} else if head := strings.TrimSpace(strings.Join(s.head, " ")); s.cxx && classHead(head) != "" {
+				s.outer = append(s.outer, outerScope{classHead(head), true, head, s.start})
+				s.module += 1
+				s.resetHead()
+			} else if s.cxx && re_lambda.MatchString(strings.SplitN(head, "{", 2)[0]) {
+				s.anon = lambdaName(head) == ""
+			}
+		}
+
+		s.global += c
+	}
+
+	if c := countPunct(tokens, "}"); c > 0 {
+		s.global -= c
+
+		for s.global < s.module && len(s.outer) > 0 {
+			o := s.outer[len(s.outer)-1]
+			s.outer = s.outer[:len(s.outer)-1]
+			s.module -= 1
+			if o.class {
+				decls = append(decls, Decl{line, clang.Cursor_StructDecl, s.qualifier() + o.name, o.head, 0, o.start})
+			}
+		}
+
+		if !s.inBody() {
+			s.anon = false
+		}
+
+		if !s.inBody() && len(s.head) > 0 {
+			decl_str := strings.TrimSpace(strings.Join(s.head, " "))
+			if s.cxx && re_lambda.MatchString(strings.SplitN(decl_str, "{", 2)[0]) {
+				// The body of a lambda is a function only when a variable holds it
+				if name := lambdaName(decl_str); name != "" {
+					decls = append(decls, Decl{line, clang.Cursor_FunctionDecl, s.qualifier() + name, decl_str, headStorage(decl_str), s.start})
+				}
+			} else if func_name := getFuncName(decl_str); func_name == "" {
+				if struct_name := getStructName(decl_str); struct_name != "" {
+					decls = append(decls, Decl{line, clang.Cursor_StructDecl, struct_name, decl_str, 0, s.start})
+				}
+			} else {
+				if s.cxx {
+					func_name = s.qualifier() + cxxFuncName(decl_str)
+				}
+				decls = append(decls, Decl{line, clang.Cursor_FunctionDecl, func_name, decl_str, headStorage(decl_str), s.start})
+			}
+			s.resetHead()
+		}
+	}
+
+	return decls
+}
+
+// outerHead tells whether tokens start a namespace or an extern block like
+// `extern "C" {`, whose body holds decls, and returns the name of the
+// namespace, e.g. "a::b" of "namespace a::b {".
+func outerHead(tokens []token) (string, bool) {
+	if len(tokens) > 1 && tokens[0].text == "inline" {
+		tokens = tokens[1:]
+	}
+	switch {
+	case len(tokens) > 0 && tokens[0].kind == TOKENIDENT && tokens[0].text == "namespace":
+		name := ""
+		for _, tk := range tokens[1:] {
+			if tk.kind != TOKENIDENT && !punct(tk, ":") {
+				break
+			}
+			name += tk.text
+		}
+		return name, true
+	case len(tokens) > 1 && tokens[0].kind == TOKENIDENT && tokens[0].text == "extern" && tokens[1].kind == TOKENSTRING:
+		return "", true
+	}
+	return "", false
+}
+
+func countPunct(tokens []token, punct string) int {
+	n := 0
+	for _, tk := range tokens {
+		if tk.kind == TOKENPUNCT && tk.text == punct {
+			n += 1
+		}
+	}
+	return n
+}
+
+// classHead returns the name of the class, struct or union whose body
+// starts at the end of head, or "" when head is something else, e.g. a
+// function or a variable initialized like "struct s x = {".
+func classHead(head string) string {
+	head = strings.SplitN(head, "{", 2)[0]
+	if strings.ContainsAny(head, "(=") {
+		return ""
+	}
+
+	if strings.HasPrefix(head, "template") {
+		depth := 0
+		for i, c := range head {
+			if c == '<' {
+				depth += 1
+			} else if c == '>' {
+				if depth -= 1; depth == 0 {
+					head = head[i+1:]
+					break
+				}
+			}
+		}
+	}
+
+	// The base classes follow ":", which is not "::"
+	for i := 0; i < len(head); i++ {
+		if head[i] == ':' {
+			if i+1 < len(head) && head[i+1] == ':' {
+				i += 1
+				continue
+			}
+			head = head[:i]
+			break
+		}
+	}
+
+	tokens := strings.Fields(head)
+	if len(tokens) < 2 {
+		return ""
+	}
+	switch tokens[0] {
+	case "class", "struct", "union":
+	default:
+		return ""
+	}
+
+	name := tokens[len(tokens)-1]
+	if name == "final" {
+		name = tokens[len(tokens)-2]
+	}
+	if !re_identifier.MatchString(name) {
+		return ""
+	}
+	return name
+}
+
+// lambdaName returns the variable initialized by the lambda whose body
+// starts at the end of head, e.g. "f" of "auto f = [](int x) {", or "" when
+// the lambda is passed to a call or the like.
+func lambdaName(head string) string {
+	head = strings.SplitN(head, "{", 2)[0]
+	loc := re_lambda.FindStringIndex(head)
+	match := re_lambda_var.FindStringSubmatchIndex(head)
+	if match == nil || match[1] != loc[1] || strings.Contains(withoutTemplateArgs(head[:loc[0]]), "(") {
+		return ""
+	}
+	return head[match[2]:match[3]]
+}
+
+// withoutTemplateArgs removes the arguments of the templates before the
+// parameters of head, e.g. "std::function<void(int)> Foo<T>::make(" is
+// taken as "std::function Foo::make(".
+func withoutTemplateArgs(head string) string {
+	b := []byte{}
+	depth := 0
+	for i := 0; i < len(head); i++ {
+		switch c := head[i]; {
+		case c == '<':
+			depth += 1
+		case c == '>' && depth > 0:
+			depth -= 1
+		case c == '(' && depth == 0:
+			return string(b) + head[i:]
+		case depth == 0:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// cxxFuncName is getFuncName which also takes qualified names, destructors,
+// operators and templates, e.g. "Foo::~Foo", "operator()" and "max" of
+// "template <typename T> T max(T a, T b)".
+func cxxFuncName(s string) string {
+	if match := re_operator.FindStringSubmatch(s); match != nil {
+		return match[1] + "operator" + strings.TrimSpace(match[2])
+	}
+	s = withoutTemplateArgs(s)
+
+	name := getFuncName(s)
+	// "Foo::~Foo" or "~Foo" which may be written as "~ Foo"
+	if tokens := strings.Fields(strings.Split(s, "(")[0]); len(tokens) > 1 && strings.HasSuffix(tokens[len(tokens)-2], "~") {
+		name = tokens[len(tokens)-2] + name
+	}
+	return name
+}
+
+// baseName is the name of a function as it is called, e.g. "bar" of
+// "ns::Foo::bar".
+func baseName(name string) string {
+	if i := strings.LastIndex(name, "::"); i >= 0 {
+		return name[i+2:]
+	}
+	return name
+}
+
+// matchName tells whether name, which may be qualified, is given by query
+// with the same or fewer qualifiers.
+func matchName(name, query string) bool {
+	return name == query || strings.HasSuffix(name, "::"+query)
+}
+
+// signature is the parameter list of the function name in head, which
+// tells an overload from the others.
+func signature(head, name string) string {
+	base := baseName(name)
+	if base == "" {
+		return ""
+	}
+	rest := ""
+	for i := strings.Index(head, base); i >= 0; i = strings.Index(head, base) {
+		head = head[i+len(base):]
+		if strings.HasPrefix(strings.TrimSpace(head), "(") {
+			rest = head
+			break
+		}
+	}
+	start := strings.Index(rest, "(")
+	if start < 0 {
+		return ""
+	}
+	depth := 0
+	for j := start; j < len(rest); j++ {
+		switch rest[j] {
+		case '(':
+			depth += 1
+		case ')':
+			if depth -= 1; depth == 0 {
+				return strings.Join(strings.Fields(rest[start:j+1]), " ")
+			}
+		}
+	}
+	return ""
+}
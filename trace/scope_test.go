@@ -0,0 +1,59 @@
+package trace
+
+import (
+	"testing"
+)
+
+func TestCxxNames(t *testing.T) {
+
+	cases := []struct {
+		head string
+		name string
+	}{
+		{"int Foo::bar(int x) const {", "Foo::bar"},
+		{"Foo::~Foo() {", "Foo::~Foo"},
+		{"Foo &Foo::operator=(const Foo &o) {", "Foo::operator="},
+		{"int operator()(int x) {", "operator()"},
+		{"int my_operator(int x) {", "my_operator"},
+		{"template <typename T> T max(T a, T b) {", "max"},
+		{"std::map<int, std::vector<int>> Foo<T>::get() {", "Foo::get"},
+		{"template <> void put<int>(int x) {", "put"},
+	}
+
+	for _, c := range cases {
+		if name := cxxFuncName(c.head); name != c.name {
+			t.Errorf("Name of %q is %q.", c.head, name)
+		}
+	}
+
+	if name := classHead("template <class T> class Foo final : public ns::Base<T> {"); name != "Foo" {
+		t.Errorf("Class is %q.", name)
+	}
+	if name := classHead("static struct ops my_ops = {"); name != "" {
+		t.Errorf("An initializer is not a class but %q.", name)
+	}
+
+	lambdas := []struct {
+		head string
+		name string
+	}{
+		{"auto f = [](int x) {", "f"},
+		{"std::function<void(int)> g = [&](int x) mutable {", "g"},
+		{"int token = reg([](int id) {", ""},
+		{"int a[2] = {", ""},
+	}
+
+	for _, c := range lambdas {
+		if name := lambdaName(c.head); name != c.name {
+			t.Errorf("Lambda of %q is %q.", c.head, name)
+		}
+	}
+
+	if sig := signature("int operator()(int x,  int y) {", "Foo::operator()"); sig != "(int x, int y)" {
+		t.Errorf("Signature is %q.", sig)
+	}
+	if sig := signature("Foo(const Foo &o) : a(o.a) {", "Foo::Foo"); sig != "(const Foo &o)" {
+		t.Errorf("Signature is %q.", sig)
+	}
+
+}
@@ -0,0 +1,86 @@
+package trace
+
+import (
+	"sort"
+)
+
+const (
+	SORTFILE  = "file"
+	SORTLINE  = "line"
+	SORTNAME  = "name"
+	SORTDEPTH = "depth"
+)
+
+func isSortKey(by string) bool {
+	return by == SORTFILE || by == SORTLINE || by == SORTNAME || by == SORTDEPTH
+}
+
+// depth is the number of levels below t, counting the shared subtree of a
+// reference as its own.
+func (t *Trace) depth(seen map[*Trace]bool) int {
+	owner := t
+	if t.ref != nil {
+		owner = t.ref
+	}
+	if seen[owner] {
+		return 0
+	}
+	seen[owner] = true
+	defer delete(seen, owner)
+
+	max := 0
+	for _, node := range owner.nodes {
+		if d := node.depth(seen) + 1; d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+func lessByFile(a, b *Trace) bool {
+	if a.entry.File != b.entry.File {
+		return a.entry.File < b.entry.File
+	}
+	if a.entry.Line != b.entry.Line {
+		return a.entry.Line < b.entry.Line
+	}
+	return a.callee.Fun < b.callee.Fun
+}
+
+// sortNodes orders nodes stably by the key given by --sort. Every key falls
+// back to the file key so that the order never depends on the search.
+func sortNodes(nodes []*Trace, by string) {
+
+	depths := make(map[*Trace]int)
+	if by == SORTDEPTH {
+		for _, node := range nodes {
+			depths[node] = node.depth(make(map[*Trace]bool))
+		}
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		switch by {
+		case SORTLINE:
+			if a.entry.Line != b.entry.Line {
+				return a.entry.Line < b.entry.Line
+			}
+		case SORTNAME:
+			if a.callee.Fun != b.callee.Fun {
+				return a.callee.Fun < b.callee.Fun
+			}
+		case SORTDEPTH:
+			if depths[a] != depths[b] {
+				return depths[a] > depths[b]
+			}
+		}
+		return lessByFile(a, b)
+	})
+}
+
+func sortTree(root *Trace, by string) {
+	sortNodes(root.nodes, by)
+	for _, node := range root.nodes {
+		sortTree(node, by)
+	}
+}
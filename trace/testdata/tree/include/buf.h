@@ -0,0 +1,8 @@
+struct buffer {
+	struct buffer *next;
+};
+
+static inline void buf_reset(struct buffer *b)
+{
+	release(b);
+}
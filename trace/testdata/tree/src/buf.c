@@ -0,0 +1,38 @@
+#include "buf.h"
+
+void free_buffer(struct buffer *b)
+{
+	release(b);
+}
+
+void process(struct packet *p)
+{
+	free_buffer(p->buf);
+	free_buffer(p->extra);
+}
+
+int check_len(int len)
+{
+	return len > 0;
+}
+
+void release(struct buffer *b)
+{
+	if (b->next) {
+		release(b->next);
+	}
+}
+
+int alloc_count;
+
+#define MAX_LEN 64
+
+void count_alloc(void)
+{
+	alloc_count += 1;
+}
+
+int over_max(int len)
+{
+	return alloc_count > MAX_LEN || len > MAX_LEN;
+}
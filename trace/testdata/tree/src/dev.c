@@ -0,0 +1,27 @@
+struct dev_ops {
+	int (*read)(int fd);
+};
+
+struct dev {
+	struct dev_ops *ops;
+};
+
+static int my_read(int fd)
+{
+	return fd;
+}
+
+static struct dev_ops my_ops = {
+	.read = my_read,
+};
+
+int dev_read(struct dev *dev)
+{
+	return dev->ops->read(0);
+}
+
+int dev_poll(void)
+{
+	int (*poll)(int) = &my_read;
+	return poll(1);
+}
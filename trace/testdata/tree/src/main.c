@@ -0,0 +1,27 @@
+int main(int argc, char **argv)
+{
+	struct packet p;
+	handle_packet(&p);
+	process(&p);
+	return 0;
+}
+
+void loop_a(int n)
+{
+	loop_b(n - 1);
+}
+
+void loop_b(int n)
+{
+	loop_a(n - 1);
+}
+
+static void dump(int n)
+{
+	loop_b(n);
+}
+
+void debug(int n)
+{
+	dump(n);
+}
@@ -0,0 +1,32 @@
+#include "buf.h"
+
+static int parse_header(struct packet *p)
+{
+	return check_len(p->len);
+}
+
+int handle_packet(struct packet *p)
+{
+	if (parse_header(p) < 0) {
+		free_buffer(p->buf);
+		return -1;
+	}
+	process(p);
+	return 0;
+}
+
+static void dump(struct packet *p)
+{
+	check_len(p->len);
+}
+
+int new_packet(struct packet *p)
+{
+	count_alloc();
+	return over_max(p->len);
+}
+
+void trace_packet(struct packet *p)
+{
+	dump(p);
+}
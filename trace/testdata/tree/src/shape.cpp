@@ -0,0 +1,25 @@
+namespace geo {
+
+class Shape {
+public:
+	Shape(int n) : sides(n) {}
+	~Shape() {}
+	int area() const { return scale(sides); }
+	int scale(int x) const;
+	int scale(double x) const;
+	bool operator==(const Shape &o) const { return sides == o.sides; }
+private:
+	int sides;
+};
+
+int Shape::scale(int x) const
+{
+	return x * 2;
+}
+
+int Shape::scale(double x) const
+{
+	return scale((int)x);
+}
+
+}
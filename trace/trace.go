@@ -0,0 +1,405 @@
+// Package trace is the Recursive Static Backtrace engine used by rsb. It
+// indexes the C sources under a root directory and builds the tree of the
+// callers, or the callees, of a function.
+package trace
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+const (
+	BTHOME = ".rsb"
+)
+
+type Entry struct {
+	File string
+	Line uint32
+}
+
+type Callee struct {
+	Fun  string
+	File string
+	Line uint32
+	Head string
+}
+
+// session is shared by every node of one search.
+type session struct {
+	dir      string
+	maxlevel int
+	forward  bool
+	refkinds RefKind // Kinds of the occurrences taken as callers
+	pool     *workerPool
+	mtx      *sync.Mutex
+	index    *Index
+	memo     map[string]*Trace // Expanded node of each function in this run
+	onEntry  func(file, fun string)
+}
+
+type Trace struct {
+	*session
+	entry  Entry
+	callee Callee
+	level  int
+	result string
+	nodes  []*Trace
+	parent *Trace
+	ref    *Trace // Set instead of nodes when expanded elsewhere
+}
+
+// Result is the line shown for the node, with ANSI colors.
+func (t *Trace) Result() string {
+	return t.result
+}
+
+func (t *Trace) Level() int {
+	return t.level
+}
+
+// Callee is the function of the node, i.e. the caller found in a backtrace
+// or the function called in a forward trace.
+func (t *Trace) Callee() Callee {
+	return t.callee
+}
+
+// Site is where the function of the node was found.
+func (t *Trace) Site() Entry {
+	return t.entry
+}
+
+func (t *Trace) Nodes() []*Trace {
+	return t.nodes
+}
+
+// Ref is the node whose subtree is shared by this node, or nil.
+func (t *Trace) Ref() *Trace {
+	return t.ref
+}
+
+// newChild makes a node for callee found at site, which is the line of the
+// call for a caller and the line in the body for a callee.
+func (t *Trace) newChild(site Entry, callee Callee, result string) *Trace {
+	return &Trace{t.session, site, callee, t.level + 1, result, nil, t, nil}
+}
+
+// addNode appends a child to t. Any goroutine may build the tree, so the
+// nodes are only modified under the lock shared by the tree.
+func (t *Trace) addNode(node *Trace) {
+	(*t.mtx).Lock()
+	defer (*t.mtx).Unlock()
+	t.nodes = append(t.nodes, node)
+}
+
+func (c Callee) key() string {
+	return fmt.Sprintf("%s@%s@%d", c.Fun, c.File, c.Line)
+}
+
+// claim registers trace as the node to expand for its function. If the
+// function is already expanded somewhere else in the tree, trace refers to
+// that subtree instead and false is returned.
+func (t *Trace) claim(trace *Trace) bool {
+	(*t.mtx).Lock()
+	defer (*t.mtx).Unlock()
+
+	if owner, ok := t.memo[trace.callee.key()]; ok {
+		trace.ref = owner
+		return false
+	}
+	t.memo[trace.callee.key()] = trace
+	return true
+}
+
+// expand queues trace to the pool, which expands it unless its subtree is
+// shared with another node.
+func (t *Trace) expand(trace *Trace) {
+	if trace.level > trace.maxlevel {
+		return
+	}
+	t.pool.Submit(trace)
+}
+
+// onPath tells whether the function of callee is t itself or one of its
+// ancestors, i.e. expanding it again would go around a cycle.
+func (t *Trace) onPath(callee Callee) bool {
+	for p := t; p != nil; p = p.parent {
+		if p.callee.Fun == callee.Fun && p.callee.File == callee.File && p.callee.Line == callee.Line {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	CYCLEMARK    = " \u21ba already on path"
+	REFMARK      = " \u2191 see above"
+	INDIRECTMARK = " indirect via %s"
+)
+
+func markResult(result, mark string) string {
+	return strings.TrimSuffix(result, "\n") + mark + "\n"
+}
+
+type Decl struct {
+	Line    uint32 // Note this indicates the last line of function or struct body
+	Kind    clang.CursorKind
+	Name    string
+	Head    string
+	Storage Storage // Only of functions
+	Start   uint32  // The first line of the head
+}
+
+type Decls []Decl
+
+func (d Decls) Less(i, j int) bool {
+	return d[i].Line < d[j].Line
+}
+
+func (d Decls) Len() int {
+	return len(d)
+}
+
+func (d Decls) Swap(i, j int) {
+	d[i], d[j] = d[j], d[i]
+}
+
+// parse finds the decls of path and the identifiers in their bodies, and
+// returns the parser which found them. With a compilation database, clang
+// parses a file with its flags and the files not in db are parsed raw.
+// When clang fails, e.g. without libclang, the raw parser is used instead.
+func parse(path, parser string, defines []string, db compDB) (Decls, []lineIdents, string, error) {
+	if parser == PARSERCLANG {
+		args, ok := []string{}, true
+		if db != nil {
+			args, ok = db.flags(path)
+		}
+		if ok {
+			decls, lines, err := parseByClang(path, append(append([]string{}, args...), clangArgs(defines)...))
+			if err == nil {
+				return decls, withBinds(lines, readIdents(path, decls, defines)), PARSERCLANG, nil
+			}
+			decls, _ = GetDeclsByRaw(path, defines...)
+			return decls, readIdents(path, decls, defines), PARSERRAW, fmt.Errorf("%s, the raw parser is used", err.Error())
+		}
+	}
+	decls, err := GetDeclsByRaw(path, defines...)
+	return decls, readIdents(path, decls, defines), PARSERRAW, err
+}
+
+// read1stFunc adds the function or struct at entry as a root of the tree.
+func (t *Trace) read1stFunc(entry Entry) {
+
+	path := entry.File
+	decls := t.index.decls[path]
+
+	for _, decl := range decls {
+
+		if entry.Line <= decl.Line {
+
+			result := ""
+			switch decl.Kind {
+			case clang.Cursor_FunctionDecl:
+				result = fmt.Sprintf("-1- Entry point %s@L%d in \x1b[34m%s\x1b[0m function scope.\n",
+					entry.File, entry.Line, t.index.label(path, decl))
+
+			case clang.Cursor_StructDecl:
+				result = fmt.Sprintf("-1- Entry point %s@L%d in \x1b[31m%s\x1b[0m struct scope.\n",
+					entry.File, entry.Line, t.index.label(path, decl))
+
+			}
+
+			if t.onEntry != nil {
+				t.onEntry(path, decl.Name)
+			}
+
+			callee := Callee{decl.Name, path, decl.Line, decl.Head}
+			trace := t.newChild(entry, callee, result)
+			t.addNode(trace)
+
+			t.expand(trace)
+
+			break
+		}
+
+	}
+
+}
+
+func GetHomeEnv() string {
+	for _, env := range os.Environ() {
+		if strings.Contains(env, "HOME=") {
+			return strings.Split(env, "=")[1]
+		}
+	}
+	return ""
+}
+
+func (t *Trace) readNthFunc() {
+
+	var last_decl_line uint32 = 1
+	last_file := ""
+
+	for _, occ := range t.index.Lookup(baseName(t.callee.Fun)) {
+		if t.pool.Cancelled() {
+			t.pool.Truncate(t)
+			return
+		}
+		if occ.file != last_file {
+			last_file = occ.file
+			last_decl_line = 1
+		}
+		if occ.kinds&t.refkinds == 0 {
+			continue
+		}
+		ok, mark := t.linkage(occ)
+		if !ok {
+			continue
+		}
+		last_decl_line = t.goWalk(occ, last_decl_line, mark)
+	}
+
+	t.readIndirect()
+}
+
+// goWalk adds the scope of occ as a caller. mark is appended to the result
+// of a caller which only may call t.callee, e.g. through a pointer.
+func (t *Trace) goWalk(occ Occurrence, last_decl_line uint32, mark string) uint32 {
+
+	if occ.decl < 0 {
+		return 1
+	}
+
+	path := occ.file
+	lines := occ.line
+	decl := t.index.decls[path][occ.decl]
+
+	h := fmt.Sprintf("%s-%d-", strings.Repeat(" ", t.level-1), t.level)
+	kind := occ.kinds & t.refkinds
+
+	switch decl.Kind {
+	case clang.Cursor_FunctionDecl:
+
+		// A call through a pointer in a header is still a call
+		if !isHeader(path) || mark != "" {
+			// Not a recursive call, while an overload of the same name is a caller
+			if t.callee.File != path || t.callee.Line != decl.Line {
+				result := markResult(fmt.Sprintf("%s %s (%s) %s@L%d in \x1b[34m%s\x1b[0m function scope.\n",
+					h, t.callee.Fun, kind, path, lines, t.index.label(path, decl)), mark)
+
+				callee := Callee{decl.Name, path, decl.Line, decl.Head}
+
+				site := Entry{path, lines}
+
+				if t.onPath(callee) {
+					t.addNode(t.newChild(site, callee, markResult(result, CYCLEMARK)))
+				} else {
+					trace := t.newChild(site, callee, result)
+					t.addNode(trace)
+
+					if decl.Line != last_decl_line {
+						t.expand(trace)
+					}
+				}
+			}
+
+		} else {
+			result := fmt.Sprintf("%s \x1b[31m%s\x1b[0m (%s) defined in %s@L%d.\n",
+				h, t.callee.Fun, kind, path, decl.Line)
+
+			callee := Callee{decl.Name, path, decl.Line, decl.Head}
+			t.addNode(t.newChild(Entry{path, lines}, callee, result))
+		}
+
+	case clang.Cursor_StructDecl:
+		result := markResult(fmt.Sprintf("%s %s (%s) %s@L%d in \x1b[31m%s\x1b[0m struct scope.\n",
+			h, t.callee.Fun, kind, path, lines, t.index.label(path, decl)), mark)
+
+		callee := Callee{decl.Name, path, decl.Line, decl.Head}
+		t.addNode(t.newChild(Entry{path, lines}, callee, result))
+	}
+
+	return decl.Line
+
+}
+
+func (t *Trace) walk() {
+	if t.forward {
+		t.readCallees()
+	} else {
+		t.readNthFunc()
+	}
+}
+
+type ShowInfo struct {
+	Result string
+	Head   string
+	Level  int
+}
+
+type ShowsInfo []ShowInfo
+
+func (shows *ShowsInfo) Join(sep string) string {
+	str := []string{}
+	for i, show := range *shows {
+		if i > 0 {
+			str = append(str, " ")
+		}
+		str = append(str, show.Result)
+	}
+	return strings.Join(str, "")
+}
+
+// DownTree flattens the tree in the order to show. A subtree shared by
+// several nodes is shown only at its first appearance and the later ones
+// are marked as references to it.
+func DownTree(root *Trace, shows *ShowsInfo) {
+	shown := make(map[*Trace]bool)
+	downSubTree(root, shows, shown, 0)
+}
+
+// shift is the difference between the level a node is shown at and the
+// level it was built at, which differs inside a shared subtree.
+func downSubTree(root *Trace, shows *ShowsInfo, shown map[*Trace]bool, shift int) {
+	if root.result == "" {
+		return
+	}
+
+	result := root.result
+	if shift != 0 {
+		result = relevel(result, root.level+shift-1)
+	}
+
+	owner := root
+	if root.ref != nil {
+		owner = root.ref
+	}
+
+	if len(owner.nodes) > 0 && shown[owner] {
+		result = markResult(result, REFMARK)
+	}
+
+	show := ShowInfo{result, root.callee.Head, root.level + shift}
+	*shows = append(*shows, show)
+
+	if len(owner.nodes) == 0 || shown[owner] {
+		return
+	}
+	shown[owner] = true
+
+	shift += root.level - owner.level
+	for _, node := range owner.nodes {
+		downSubTree(node, shows, shown, shift)
+	}
+}
+
+// relevel replaces the "-N-" prefix of a result built for another level.
+func relevel(result string, level int) string {
+	re_level, _ := regexp.Compile("^ *-\\d+-")
+	h := fmt.Sprintf("%s-%d-", strings.Repeat(" ", level-1), level)
+	return re_level.ReplaceAllLiteralString(result, h)
+}
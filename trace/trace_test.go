@@ -0,0 +1,40 @@
+package trace
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDownTreeSharedSubtree(t *testing.T) {
+
+	root := Trace{result: "root\n", level: 1}
+
+	// b is expanded under a at level 3 and referred from the root at level 2
+	a := root.newChild(Entry{}, Callee{"a", "x.c", 3, ""}, "-1- a\n")
+	b := a.newChild(Entry{}, Callee{"b", "x.c", 9, ""}, " -2- b\n")
+	c := b.newChild(Entry{}, Callee{"c", "x.c", 12, ""}, "  -3- c\n")
+	b_ref := root.newChild(Entry{}, Callee{"b", "x.c", 9, ""}, "-1- b\n")
+	b_ref.ref = b
+
+	// Shown first through the reference, so the subtree moves up one level
+	root.nodes = []*Trace{b_ref, a}
+	a.nodes = []*Trace{b}
+	b.nodes = []*Trace{c}
+
+	shows := ShowsInfo{}
+	DownTree(&root, &shows)
+
+	expected := []string{"root\n", "-1- b\n", " -2- c\n", "-1- a\n", " -2- b" + REFMARK + "\n"}
+	if len(shows) != len(expected) {
+		t.Fatalf("Unexpected tree:\n%s", shows.Join(""))
+	}
+	for i, show := range shows {
+		if show.Result != expected[i] {
+			t.Errorf("%d: %q is not %q", i, show.Result, expected[i])
+		}
+	}
+
+	if shows[2].Level != 3 || !strings.HasPrefix(relevel("  -3- c\n", 1), "-1-") {
+		t.Errorf("Shared subtree is not releveled.")
+	}
+}
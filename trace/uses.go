@@ -0,0 +1,269 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-clang/bootstrap/clang"
+)
+
+// Kinds of Options.Kind, i.e. what Options.Symbol is.
+const (
+	KINDSTRUCT = "struct"
+	KINDGLOBAL = "global"
+	KINDMACRO  = "macro"
+)
+
+func isKind(kind string) bool {
+	return kind == KINDSTRUCT || kind == KINDGLOBAL || kind == KINDMACRO
+}
+
+// sourceLine is a line of code without strings and comments, and its tokens.
+type sourceLine struct {
+	text   string
+	tokens []token
+}
+
+// sourceLines returns the lines of path, where a line is at its number minus
+// one. The lines in the branches of #if not parsed are empty.
+func sourceLines(path string, defines []string) []sourceLine {
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer fd.Close()
+
+	pp := newPreprocessor(defines)
+
+	tz := newTokenizer(fd, isCxx(path))
+
+	lines := []sourceLine{}
+
+	for tz.scan() {
+		ln := sourceLine{tz.code, tz.tokens}
+		if !pp.line(ln.text) && !(pp.active() && strings.HasPrefix(strings.TrimSpace(ln.text), "#")) {
+			// Directives are kept for the definitions of macros
+			ln = sourceLine{}
+		}
+		lines = append(lines, ln)
+	}
+
+	return lines
+}
+
+// hasIdent tells whether name is one of the identifiers of tokens.
+func hasIdent(tokens []token, name string) bool {
+	for _, tk := range tokens {
+		if tk.kind == TOKENIDENT && tk.text == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readNames returns the distinct identifiers of path, including the ones of
+// directives and of the branches of #if, so that the files where a symbol
+// may be used are known from the index.
+func readNames(path string) []string {
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer fd.Close()
+
+	seen := make(map[string]bool)
+	names := []string{}
+
+	tz := newTokenizer(fd, isCxx(path))
+	for tz.scan() {
+		for _, tk := range tz.tokens {
+			if tk.kind == TOKENIDENT && !seen[tk.text] {
+				seen[tk.text] = true
+				names = append(names, tk.text)
+			}
+		}
+	}
+	return names
+}
+
+// scopeOf returns the decl enclosing line, which is either in the body or in
+// the head of the decl, or -1 at file scope. scoped maps the lines in the
+// bodies to their decls.
+func (idx *Index) scopeOf(path string, scoped map[uint32]int, line uint32, text string) int {
+	if decl, ok := scoped[line]; ok {
+		return decl
+	}
+	decls := idx.decls[path]
+	i := findDecl(decls, line)
+	if text = strings.TrimSpace(text); i >= 0 && text != "" && strings.Contains(decls[i].Head, text) {
+		return i
+	}
+	return -1
+}
+
+// symbol matches the lines where a struct, global or macro is defined,
+// used and written.
+type symbol struct {
+	kind   string
+	name   string
+	use    *regexp.Regexp
+	define *regexp.Regexp
+	write  []*regexp.Regexp
+}
+
+func newSymbol(kind, name string) *symbol {
+	q := regexp.QuoteMeta(name)
+	sym := &symbol{kind, name, nil, nil, nil}
+
+	switch kind {
+	case KINDSTRUCT:
+		sym.use = regexp.MustCompile(`\bstruct\s+` + q + `\b`)
+		sym.define = regexp.MustCompile(`\bstruct\s+` + q + `\s*\{`)
+	case KINDMACRO:
+		sym.use = regexp.MustCompile(`\b` + q + `\b`)
+		sym.define = regexp.MustCompile(`^\s*#\s*define\s+` + q + `\b`)
+	case KINDGLOBAL:
+		// Not a member of some struct which has the same name
+		sym.use = regexp.MustCompile(`(^|[^\w.>]|[^-]>)` + q + `\b`)
+		sym.write = []*regexp.Regexp{
+			regexp.MustCompile(`(^|[^\w.>]|[^-]>)` + q + `\s*(\[[^\]]*\]|\.\w+|->\w+)*\s*([-+*/%&|^]|<<|>>)?=($|[^=])`),
+			regexp.MustCompile(`(\+\+|--)\s*` + q + `\b`),
+			regexp.MustCompile(`\b` + q + `\s*(\+\+|--)`),
+			regexp.MustCompile(`(^|[^&])&\s*` + q + `\b`),
+		}
+	}
+	return sym
+}
+
+// isDefinition tells whether text in decl defines the symbol. A global is
+// defined by any line at file scope except extern declarations.
+func (sym *symbol) isDefinition(text string, decl int) bool {
+	switch sym.kind {
+	case KINDGLOBAL:
+		text = strings.TrimSpace(text)
+		return decl < 0 && !strings.HasPrefix(text, "extern") && !strings.HasPrefix(text, "#")
+	}
+	return sym.define.MatchString(text)
+}
+
+// isWritten tells whether a global is assigned, incremented or has its
+// address taken, which may be for writing too.
+func (sym *symbol) isWritten(text string) bool {
+	for _, re := range sym.write {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+type use struct {
+	path string
+	line uint32
+	text string
+	decl int
+}
+
+// readUses adds the definition of the symbol as the root of the tree and its
+// uses under it. The function of each use is expanded like a caller.
+func (t *Trace) readUses(sym *symbol) {
+
+	var def *Trace
+	uses := []use{}
+
+	// Only the files which have the name are read again
+	for _, path := range t.index.names[sym.name] {
+
+		scoped := make(map[uint32]int)
+		for _, ln := range t.index.lines[path] {
+			scoped[ln.line] = ln.decl
+		}
+
+		for i, ln := range sourceLines(path, t.index.defines) {
+
+			text := ln.text
+			if !hasIdent(ln.tokens, sym.name) || !sym.use.MatchString(text) {
+				continue
+			}
+
+			line := uint32(i + 1)
+			decl := t.index.scopeOf(path, scoped, line, text)
+
+			if def == nil && sym.isDefinition(text, decl) {
+				result := fmt.Sprintf("-1- %s \x1b[31m%s\x1b[0m defined in %s@L%d.\n", sym.kind, sym.name, path, line)
+				def = t.newChild(Entry{path, line}, Callee{sym.name, path, line, strings.TrimSpace(text)}, result)
+				continue
+			}
+
+			uses = append(uses, use{path, line, text, decl})
+		}
+	}
+
+	if def == nil {
+		result := fmt.Sprintf("-1- %s \x1b[31m%s\x1b[0m defined nowhere under %s.\n", sym.kind, sym.name, t.dir)
+		def = t.newChild(Entry{}, Callee{sym.name, "", 0, ""}, result)
+	}
+	t.addNode(def)
+
+	var last_decl_line uint32 = 1
+	last_file := ""
+
+	for _, u := range uses {
+		if u.path != last_file {
+			last_file = u.path
+			last_decl_line = 1
+		}
+		last_decl_line = def.addUse(sym, u, last_decl_line)
+	}
+}
+
+// addUse adds the node of a use. Its function is expanded only at the first
+// use in the function, like the callers in goWalk.
+func (t *Trace) addUse(sym *symbol, u use, last_decl_line uint32) uint32 {
+
+	h := fmt.Sprintf("%s-%d-", strings.Repeat(" ", t.level-1), t.level)
+	site := Entry{u.path, u.line}
+
+	what := sym.name
+	if sym.kind == KINDGLOBAL {
+		if sym.isWritten(u.text) {
+			what += " (write)"
+		} else {
+			what += " (read)"
+		}
+	}
+
+	if u.decl < 0 {
+		// Globals are only searched in functions and extern declarations are
+		// not uses
+		if sym.kind == KINDGLOBAL || strings.HasPrefix(strings.TrimSpace(u.text), "extern") {
+			return last_decl_line
+		}
+		result := fmt.Sprintf("%s %s %s@L%d at file scope.\n", h, what, u.path, u.line)
+		t.addNode(t.newChild(site, Callee{}, result))
+		return last_decl_line
+	}
+
+	decl := t.index.decls[u.path][u.decl]
+	callee := Callee{decl.Name, u.path, decl.Line, decl.Head}
+
+	switch decl.Kind {
+	case clang.Cursor_FunctionDecl:
+		result := fmt.Sprintf("%s %s %s@L%d in \x1b[34m%s\x1b[0m function scope.\n", h, what, u.path, u.line, t.index.label(u.path, decl))
+		trace := t.newChild(site, callee, result)
+		t.addNode(trace)
+		if decl.Line != last_decl_line {
+			t.expand(trace)
+		}
+
+	case clang.Cursor_StructDecl:
+		result := fmt.Sprintf("%s %s %s@L%d in \x1b[31m%s\x1b[0m struct scope.\n", h, what, u.path, u.line, t.index.label(u.path, decl))
+		t.addNode(t.newChild(site, callee, result))
+	}
+
+	return decl.Line
+}
@@ -0,0 +1,52 @@
+package trace
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGlobalWritten(t *testing.T) {
+
+	sym := newSymbol(KINDGLOBAL, "count")
+
+	cases := map[string]bool{
+		"count = 0;":              true,
+		"count += n;":             true,
+		"count++;":                true,
+		"--count;":                true,
+		"table[i].count = 1;":     false,
+		"count[i] = 1;":           true,
+		"reset(&count);":          true,
+		"if (count == 0)":         false,
+		"return count > max;":     false,
+		"p->count = 1;":           false,
+		"if (a && count) return;": false,
+	}
+
+	for text, written := range cases {
+		if sym.use.MatchString(text) && sym.isWritten(text) != written {
+			t.Errorf("%s failed.", text)
+		}
+	}
+
+}
+
+func TestReadNames(t *testing.T) {
+
+	names := readNames(filepath.Join("testdata", "tree", "src", "buf.c"))
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			t.Errorf("%s is repeated.", name)
+		}
+		seen[name] = true
+	}
+	if !seen["release"] || !seen["free_buffer"] || !seen["include"] {
+		t.Errorf("Names are missing in %v.", names)
+	}
+	if seen["handle_packet"] {
+		t.Errorf("A name of another file is in %v.", names)
+	}
+
+}
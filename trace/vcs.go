@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Modes of Config.VCS, i.e. which files of a git repository are searched.
+const (
+	VCSAUTO    = "auto"    // VCSIGNORE inside a git repository, VCSNONE otherwise
+	VCSIGNORE  = "ignore"  // Skip the files ignored by .gitignore, info/exclude and core.excludesFile
+	VCSTRACKED = "tracked" // Only the files in git ls-files
+	VCSNONE    = "none"    // Every file under the roots
+)
+
+func isVCSMode(mode string) bool {
+	switch mode {
+	case VCSAUTO, VCSIGNORE, VCSTRACKED, VCSNONE:
+		return true
+	}
+	return false
+}
+
+func (cfg *Config) vcs() string {
+	if cfg == nil || cfg.VCS == "" {
+		return VCSAUTO
+	}
+	return cfg.VCS
+}
+
+func inGitRepo(dir string) bool {
+	abs_dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	for d := abs_dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return true
+		}
+		if d == filepath.Dir(d) {
+			return false
+		}
+	}
+}
+
+// gitFiles lists the files under root, relative to it, as git sees them.
+// The ignore rules are left to git itself so that they match git status.
+func gitFiles(root string, tracked bool) ([]string, error) {
+	args := []string{"-C", root, "ls-files", "-z", "--cached"}
+	if !tracked {
+		args = append(args, "--others", "--exclude-standard")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files in %s failed: %s %s", root, err.Error(),
+			strings.TrimSpace(stderr.String()))
+	}
+
+	seen := make(map[string]bool)
+	files := []string{}
+	for _, file := range strings.Split(string(out), "\x00") {
+		if file != "" && !seen[file] {
+			seen[file] = true
+			files = append(files, filepath.FromSlash(file))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// listFiles returns the files under root chosen by the VCS mode, or nil
+// when root is to be walked as it is.
+func (cfg *Config) listFiles(root string) ([]string, error) {
+	mode := cfg.vcs()
+
+	if mode == VCSNONE || (mode == VCSAUTO && !inGitRepo(root)) {
+		return nil, nil
+	}
+	if !inGitRepo(root) {
+		return nil, fmt.Errorf("%s is not in a git repository, vcs = %q is ignored", root, mode)
+	}
+
+	return gitFiles(root, mode == VCSTRACKED)
+}
@@ -0,0 +1,59 @@
+package trace
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGitWalk(t *testing.T) {
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed.")
+	}
+
+	root, _ := ioutil.TempDir("", "rsb-root")
+	defer os.RemoveAll(root)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", root}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+
+	for _, file := range []string{"a.c", "b.c", "build/gen.c", ".gitignore"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, file)), 0755)
+		ioutil.WriteFile(filepath.Join(root, file), []byte(""), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(root, ".gitignore"), []byte("build/\n"), 0644)
+
+	git("init", "-q")
+	git("add", "a.c", ".gitignore")
+
+	walked := func(cfg *Config) []string {
+		files := []string{}
+		store := &IndexStore{INDEXVERSION, root, "", nil, "", make(map[string]*FileRecord), "", cfg, "", nil}
+		store.walk(func(path, rel string, info os.FileInfo) {
+			files = append(files, rel)
+		})
+		if len(store.Warnings()) > 0 {
+			t.Errorf("Unexpected warnings: %v", store.Warnings())
+		}
+		return files
+	}
+
+	if files := walked(nil); !reflect.DeepEqual(files, []string{"a.c", "b.c"}) {
+		t.Errorf("Unexpected files with auto: %v", files)
+	}
+	if files := walked(&Config{VCS: VCSTRACKED}); !reflect.DeepEqual(files, []string{"a.c"}) {
+		t.Errorf("Unexpected files with tracked: %v", files)
+	}
+	if files := walked(&Config{VCS: VCSNONE}); !reflect.DeepEqual(files, []string{"a.c", "b.c", "build/gen.c"}) {
+		t.Errorf("Unexpected files with none: %v", files)
+	}
+
+}